@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// the extensions of the archives decompressed with Decompress
+var archiveExtensions = map[string]bool{
+	".gz":  true,
+	".bz2": true,
+	".zst": true,
+}
+
+// isArchive returns true if path is an archive harvested decompressed
+func (f *FileConfig) isArchive(path string) bool {
+	return f.Decompress && archiveExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// newDecompressor returns the decompressed content of the archive read from r
+func newDecompressor(path string, r io.Reader) (io.ReadCloser, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gz":
+		return gzip.NewReader(r)
+	case ".bz2":
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	case ".zst":
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("%s is not a .gz, .bz2 or .zst archive", path)
+}
+
+// openArchive decompresses the file of the harvester, from its Offset in the
+// decompressed content
+func (h *Harvester) openArchive() error {
+	archive, err := newDecompressor(h.Path, h.file)
+	if err != nil {
+		return err
+	}
+	if _, err = io.CopyN(ioutil.Discard, archive, h.Offset); err != nil {
+		archive.Close()
+		return fmt.Errorf("could not skip to offset %d: %s", h.Offset, err)
+	}
+	h.archive = archive
+	return nil
+}
+
+// send ships the event downstream, with the fingerprint of the file. the last
+// event of an archive is held until the end of the archive, to mark it done.
+func (h *Harvester) send(event *FileEvent, output chan *FileEvent) {
+	h.updateFingerprint()
+	event.Fingerprint, event.FingerprintSize = h.fingerprint, h.fingerprintSize
+
+	if h.archive == nil {
+		output <- event
+		return
+	}
+	if h.pending != nil {
+		output <- h.pending
+	}
+	h.pending = event
+}
+
+// finishArchive ships the event held, done if the end of the archive was read.
+// if no event is held, the end of the archive is saved by a StateOnly event.
+func (h *Harvester) finishArchive(output chan *FileEvent, done bool, info *os.FileInfo) {
+	if h.pending == nil {
+		if !done {
+			return
+		}
+		text := ""
+		h.pending = h.newEvent(&text, 0, info)
+		h.pending.EndOffset = h.Offset
+		h.pending.StateOnly = true
+		h.updateFingerprint()
+		h.pending.Fingerprint, h.pending.FingerprintSize = h.fingerprint, h.fingerprintSize
+	}
+	h.pending.Done = done
+	output <- h.pending
+	h.pending = nil
+}
+
+// archiveHead returns the first fingerprintSize bytes of the decompressed
+// content of the archive at path
+func archiveHead(path string) ([]byte, error) {
+	file, err := openfile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	archive, err := newDecompressor(path, file)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	head := make([]byte, fingerprintSize)
+	n, err := io.ReadFull(archive, head)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return head[:n], err
+}
+
+// is_archive_of returns true if the archive starts with the bytes of a file
+// fingerprinted, it is that file compressed by the log rotation
+func is_archive_of(head []byte, sum string, size int64) bool {
+	if sum == "" || size == 0 || size > int64(len(head)) {
+		return false
+	}
+	headsum, err := fingerprint(bytes.NewReader(head), size)
+	return err == nil && headsum == sum
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const archiveContent = "first line\nsecond line\nlast line"
+
+func writeArchive(t *testing.T, path string) {
+	file, err := os.Create(path)
+	chkerr(t, err)
+	defer file.Close()
+
+	switch filepath.Ext(path) {
+	case ".gz":
+		writer := gzip.NewWriter(file)
+		_, err = writer.Write([]byte(archiveContent))
+		chkerr(t, err)
+		chkerr(t, writer.Close())
+	case ".zst":
+		writer, err := zstd.NewWriter(file)
+		chkerr(t, err)
+		_, err = writer.Write([]byte(archiveContent))
+		chkerr(t, err)
+		chkerr(t, writer.Close())
+	case ".bz2":
+		// there is no bzip2 writer in the standard library
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "archive.log.bz2"))
+		chkerr(t, err)
+		_, err = file.Write(data)
+		chkerr(t, err)
+	}
+}
+
+func harvestArchive(t *testing.T, path string, offset int64) ([]*FileEvent, int64) {
+	fileconfig := FileConfig{Decompress: true, MaxBytes: 1024}
+	output := make(chan *FileEvent, 10)
+	h := &Harvester{Path: path, FileConfig: fileconfig, Offset: offset, FinishChan: make(chan int64, 1)}
+	h.Harvest(output)
+	close(output)
+
+	var events []*FileEvent
+	for event := range output {
+		events = append(events, event)
+	}
+	return events, <-h.FinishChan
+}
+
+func TestHarvestArchives(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	for _, ext := range []string{".gz", ".bz2", ".zst"} {
+		path := filepath.Join(tmpdir, "app.log.1"+ext)
+		writeArchive(t, path)
+
+		events, offset := harvestArchive(t, path, 0)
+		expected := []string{"first line", "second line", "last line"}
+		if len(events) != len(expected) {
+			t.Fatalf("%s: expected %d events, got %d", ext, len(expected), len(events))
+		}
+		for i, event := range events {
+			if *event.Text != expected[i] || event.Done != (i == len(events)-1) {
+				t.Fatalf("%s: unexpected event %d %q, done %t", ext, i, *event.Text, event.Done)
+			}
+		}
+		if end := int64(len(archiveContent)); events[2].EndOffset != end || offset != end {
+			t.Fatalf("%s: expected the end at %d, got %d and %d", ext, end, events[2].EndOffset, offset)
+		}
+
+		// the offsets are in the decompressed content
+		events, _ = harvestArchive(t, path, events[0].EndOffset)
+		if len(events) != 2 || *events[0].Text != "second line" || events[0].Offset != 11 {
+			t.Fatalf("%s: expected to resume at the second line, got %d events", ext, len(events))
+		}
+	}
+}
+
+func TestHarvestArchiveWithoutDecompress(t *testing.T) {
+	fileconfig := &FileConfig{}
+	if fileconfig.isArchive("/var/log/app.log.1.gz") {
+		t.Fatalf("Expected archives to be decompressed only with Decompress")
+	}
+	fileconfig.Decompress = true
+	if !fileconfig.isArchive("/var/log/app.log.1.GZ") || fileconfig.isArchive("/var/log/app.log.1") {
+		t.Fatalf("Expected only .gz, .bz2 and .zst files to be archives")
+	}
+}
+
+func TestProspectorSkipsDoneArchives(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	done := filepath.Join(tmpdir, "done.log.gz")
+	partial := filepath.Join(tmpdir, "partial.log.gz")
+	writeArchive(t, done)
+	writeArchive(t, partial)
+
+	registrar := make(map[string]*FileState)
+	output := make(chan *FileEvent, 10)
+	for _, path := range []string{done, partial} {
+		events, _ := harvestArchive(t, path, 0)
+		fileinfo, err := os.Stat(path)
+		chkerr(t, err)
+		for _, event := range events {
+			event.fileinfo = &fileinfo
+		}
+		// the registrar only saw the first line of partial
+		if path == partial {
+			events = events[:1]
+		}
+		input := make(chan []*FileEvent, 1)
+		input <- events
+		close(input)
+		Registrar(registrar, filepath.Join(tmpdir, ".logstash-forwarder"), nil, input)
+	}
+	if !registrar[done].Done || registrar[partial].Done || registrar[partial].Offset != 11 {
+		t.Fatalf("Unexpected registrar states %+v and %+v", *registrar[done], *registrar[partial])
+	}
+
+	resume := &ProspectorResume{files: registrar, persist: make(chan *FileState, 10)}
+	p := &Prospector{FileConfig: FileConfig{Paths: []string{filepath.Join(tmpdir, "*.gz")}, Decompress: true, MaxBytes: 1024}}
+	p.prospectorinfo = make(map[string]ProspectorInfo)
+	p.scan(p.FileConfig.Paths[0], output, resume)
+
+	event := <-output
+	if *event.Source != partial || *event.Text != "second line" {
+		t.Fatalf("Expected partial to be resumed, got %q from %s", *event.Text, *event.Source)
+	}
+	if offset := <-p.prospectorinfo[done].harvester; offset != int64(len(archiveContent)) {
+		t.Fatalf("Expected done to be skipped at its end, got %d", offset)
+	}
+}
+
+func TestArchiveWithoutEventsIsDone(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	path := filepath.Join(tmpdir, "app.log.1.gz")
+	writeArchive(t, path)
+
+	fileconfig := FileConfig{Decompress: true, MaxBytes: 1024, ExcludeLines: []string{"line"}}
+	chkerr(t, compileLineFilters(&fileconfig))
+	output := make(chan *FileEvent, 10)
+	h := &Harvester{Path: path, FileConfig: fileconfig, FinishChan: make(chan int64, 1)}
+	h.Harvest(output)
+
+	event := <-output
+	if !event.Done || !event.StateOnly || event.EndOffset != int64(len(archiveContent)) || len(output) != 0 {
+		t.Fatalf("Expected one state only event at the end of the archive, got %+v", event)
+	}
+	if event.Fingerprint == "" || event.fileinfo == nil {
+		t.Fatalf("Expected the state of the archive to be saved by the registrar")
+	}
+}
+
+func TestArchiveOfHarvestedFile(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	path := filepath.Join(tmpdir, "app.log.1.gz")
+	writeArchive(t, path)
+	fileconfig := FileConfig{Paths: []string{filepath.Join(tmpdir, "*")}, Decompress: true, MaxBytes: 1024}
+
+	// the agent was stopped after the first line of app.log.1, compressed since
+	source := filepath.Join(tmpdir, "app.log.1")
+	sum, err := fingerprint(strings.NewReader(archiveContent), 11)
+	chkerr(t, err)
+	resume := &ProspectorResume{
+		files:   map[string]*FileState{source: {Source: &source, Offset: 11, Fingerprint: sum, FingerprintSize: 11}},
+		persist: make(chan *FileState, 10),
+	}
+	output := make(chan *FileEvent, 10)
+	p := &Prospector{FileConfig: fileconfig, prospectorinfo: make(map[string]ProspectorInfo)}
+	p.scan(fileconfig.Paths[0], output, resume)
+	if event := <-output; *event.Text != "second line" {
+		t.Fatalf("Expected the archive to be resumed at the second line, got %q", *event.Text)
+	}
+
+	// compressed while the agent runs, the harvester of app.log.1 read two lines
+	sum, err = fingerprint(strings.NewReader(archiveContent), 23)
+	chkerr(t, err)
+	harvester := make(chan int64, 1)
+	harvester <- 23
+	output = make(chan *FileEvent, 10)
+	p = &Prospector{FileConfig: fileconfig, lastscan: time.Now()}
+	// app.log.1 itself was removed by the compression
+	removed, err := os.Stat(tmpdir)
+	chkerr(t, err)
+	p.prospectorinfo = map[string]ProspectorInfo{source: {fileinfo: removed, harvester: harvester, fingerprint: sum, fingerprintSize: 23}}
+	p.scan(fileconfig.Paths[0], output, nil)
+	if event := <-output; *event.Text != "last line" || !event.Done {
+		t.Fatalf("Expected the archive to be harvested from the end of app.log.1, got %q", *event.Text)
+	}
+
+	// a touched archive is not harvested again
+	for len(p.prospectorinfo[path].harvester) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	later := time.Now().Add(time.Minute)
+	chkerr(t, os.Chtimes(path, later, later))
+	p.scan(fileconfig.Paths[0], output, nil)
+	select {
+	case event := <-output:
+		t.Fatalf("Expected the archive not to be harvested again, got %q", *event.Text)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestArchiveRenamed(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	archive := func(n int) string { return filepath.Join(tmpdir, fmt.Sprintf("app.log.%d.gz", n)) }
+	writeArchive(t, archive(1))
+	fileconfig := FileConfig{Paths: []string{filepath.Join(tmpdir, "*.gz")}, Decompress: true, MaxBytes: 1024}
+	p := &Prospector{FileConfig: fileconfig, prospectorinfo: make(map[string]ProspectorInfo), lastscan: time.Now()}
+
+	output := make(chan *FileEvent, 10)
+	p.scan(fileconfig.Paths[0], output, nil)
+	for i := 0; i < 3; i++ {
+		<-output
+	}
+	for len(p.prospectorinfo[archive(1)].harvester) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// renamed by the log rotation, it is not harvested again
+	chkerr(t, os.Rename(archive(1), archive(2)))
+	p.scan(fileconfig.Paths[0], output, nil)
+	if p.prospectorinfo[archive(2)].harvester != p.prospectorinfo[archive(1)].harvester {
+		t.Fatalf("Expected the renamed archive to keep its harvester")
+	}
+
+	// renamed again, and a new archive takes its name
+	chkerr(t, os.Rename(archive(2), archive(3)))
+	file, err := os.Create(archive(2))
+	chkerr(t, err)
+	writer := gzip.NewWriter(file)
+	writer.Write([]byte("rotated line"))
+	chkerr(t, writer.Close())
+	chkerr(t, file.Close())
+	p.scan(fileconfig.Paths[0], output, nil)
+
+	if event := <-output; *event.Source != archive(2) || *event.Text != "rotated line" {
+		t.Fatalf("Expected only the new archive harvested, got %q from %s", *event.Text, *event.Source)
+	}
+	select {
+	case event := <-output:
+		t.Fatalf("Expected the renamed archives not to be harvested again, got %q from %s", *event.Text, *event.Source)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if offset := <-p.prospectorinfo[archive(3)].harvester; offset != int64(len(archiveContent)) {
+		t.Errorf("Expected the renamed archive to keep its offset, got %d", offset)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// CSVCodecConfig :
+// separator: one character between the columns, default "," for csv, "\t" for tsv and " " for w3c
+// header: the first line of the file is the header row with the column names (csv and tsv).
+// w3c logs always take their columns from the "#Fields:" directives, which could change
+// in the middle of the file. the other directives are skipped.
+// values are quoted as in RFC 4180. until a header is read, FieldNames are the columns.
+type CSVCodecConfig struct {
+	Separator string `json:"separator"`
+	Header    bool   `json:"header"`
+}
+
+// tag added to the event if the line could not be split into its columns
+const csvFailureTag = "_csvparsefailure"
+
+const w3cFieldsDirective = "#Fields:"
+
+var csvSeparators = map[string]string{
+	"csv": ",",
+	"tsv": "\t",
+	"w3c": " ",
+}
+
+func isCSVCodec(codec string) bool {
+	_, ok := csvSeparators[codec]
+	return ok
+}
+
+// csvSeparator returns the separator of the codec, the configured one or its default
+func csvSeparator(codec string, cc *CSVCodecConfig) rune {
+	separator := csvSeparators[codec]
+	if cc != nil && cc.Separator != "" {
+		separator = cc.Separator
+	}
+	r, _ := utf8.DecodeRuneInString(separator)
+	return r
+}
+
+func (cc *CSVCodecConfig) check() error {
+	if utf8.RuneCountInString(cc.Separator) > 1 {
+		return fmt.Errorf("csv codec: separator %q should be one character", cc.Separator)
+	}
+	switch cc.Separator {
+	case "\r", "\n", "\"":
+		return fmt.Errorf("csv codec: invalid separator %q", cc.Separator)
+	}
+	return nil
+}
+
+// splitCSV splits a line into its values, quoted as in RFC 4180
+func splitCSV(text string, separator rune) ([]string, error) {
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = separator
+	reader.FieldsPerRecord = -1
+	return reader.Read()
+}
+
+// csvHeader holds the columns of the file a harvester reads, updated by the
+// header lines of it
+type csvHeader struct {
+	codec      string
+	conf       *CSVCodecConfig
+	columns    []string
+	headerRead bool
+}
+
+func newCSVHeader(fileconfig *FileConfig) *csvHeader {
+	if !isCSVCodec(fileconfig.Codec) {
+		return nil
+	}
+	return &csvHeader{codec: fileconfig.Codec, conf: fileconfig.CSV, columns: fileconfig.FieldNames}
+}
+
+// header reads the columns from text if it is a header line or a directive.
+// it returns false for the lines of data.
+func (c *csvHeader) header(text string) bool {
+	if c.codec == "w3c" {
+		if !strings.HasPrefix(text, "#") {
+			return false
+		}
+		if strings.HasPrefix(text, w3cFieldsDirective) {
+			c.columns = strings.Fields(text[len(w3cFieldsDirective):])
+		}
+		return true
+	}
+
+	if c.conf == nil || !c.conf.Header || c.headerRead {
+		return false
+	}
+	c.headerRead = true
+	if columns, err := splitCSV(text, csvSeparator(c.codec, c.conf)); err == nil {
+		c.columns = columns
+	} else {
+		emit("Could not read the header of %s codec %q: %s\n", c.codec, text, err)
+	}
+	return true
+}
+
+// recover reads the header lines before offset, when the harvest resumes in
+// the middle of a file
+func (c *csvHeader) recover(path string, offset int64) {
+	file, err := os.Open(path)
+	if err != nil {
+		emit("Could not read the header of %s: %s\n", path, err)
+		return
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var read int64
+	for read < offset {
+		line, err := reader.ReadString('\n')
+		read += int64(len(line))
+		if err != nil && (err != io.EOF || line == "") {
+			return
+		}
+		if c.header(strings.TrimRight(line, "\r\n")) == false && c.codec != "w3c" {
+			// csv has its header on the first line only
+			return
+		}
+	}
+}
+
+// reset forgets the columns read, the file was truncated
+func (c *csvHeader) reset(fileconfig *FileConfig) {
+	c.columns = fileconfig.FieldNames
+	c.headerRead = false
+}
+
+// csvFields writes the values of the line with the names of its columns,
+// converted to their CaptureTypes. keys of Fields, host, path and @timestamp
+// are dropped. it returns false if the line does not match the columns.
+func (f *formatState) csvFields(event *FileEvent) bool {
+	values, err := splitCSV(*event.Text, csvSeparator(event.Codec, event.CSV))
+	if err != nil || len(event.Columns) == 0 || len(values) != len(event.Columns) {
+		f.tag(csvFailureTag)
+		return false
+	}
+
+	reserved := eventKeys(event)
+	for i, name := range event.Columns {
+		if _, ok := f.values[name]; ok || reserved[name] {
+			continue
+		}
+		f.typedField(event, name, values[i], event.CaptureTypes[name])
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+func TestCSVCodec(t *testing.T) {
+	event := newTestEvent(`GET,"/a,b","say ""hi""",200`)
+	event.Codec = "csv"
+	event.Columns = []string{"method", "uri", "note", "status"}
+	event.CaptureTypes = map[string]string{"status": "integer"}
+
+	decoded := decodeJsonFormat(t, event)
+	if decoded["uri"] != "/a,b" || decoded["note"] != `say "hi"` || decoded["status"] != float64(200) {
+		t.Errorf("Unexpected csv fields: %v", decoded)
+	}
+
+	// a line with more values than columns is sent as message
+	text := "GET,/a,b,c,200"
+	event.Text = &text
+	decoded = decodeJsonFormat(t, event)
+	if decoded["message"] != text {
+		t.Errorf("Expected the line sent as message, got %v", decoded)
+	}
+	if tags, _ := decoded["tags"].([]interface{}); len(tags) != 1 || tags[0] != csvFailureTag {
+		t.Errorf("Expected %s tag, got %v", csvFailureTag, decoded["tags"])
+	}
+}
+
+func TestW3CHeader(t *testing.T) {
+	fileconfig := &FileConfig{Codec: "w3c"}
+	header := newCSVHeader(fileconfig)
+
+	if !header.header("#Software: Microsoft Internet Information Services 7.5") {
+		t.Fatalf("Expected directives to be skipped")
+	}
+	if !header.header("#Fields: date time cs-method sc-status") || len(header.columns) != 4 {
+		t.Fatalf("Expected 4 columns, got %v", header.columns)
+	}
+	if header.header("2015-01-02 03:04:05 GET 200") {
+		t.Fatalf("Expected a data line")
+	}
+
+	// the fields could change in the middle of the file
+	header.header("#Fields: date time cs-method")
+	event := newTestEvent("2015-01-02 03:04:05 GET")
+	event.Codec = "w3c"
+	event.Columns = header.columns
+	decoded := decodeJsonFormat(t, event)
+	if decoded["date"] != "2015-01-02" || decoded["cs-method"] != "GET" {
+		t.Errorf("Unexpected w3c fields: %v", decoded)
+	}
+}
+
+func TestCSVHeaderRecover(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	content := "#Version: 1.0\n#Fields: date cs-method\n2015-01-02 GET\n#Fields: date cs-method sc-status\n2015-01-02 GET 200\n"
+	file := path.Join(tmpdir, "u_ex150102.log")
+	chkerr(t, ioutil.WriteFile(file, []byte(content), 0644))
+
+	// resuming after the second #Fields gives its columns
+	header := newCSVHeader(&FileConfig{Codec: "w3c"})
+	header.recover(file, int64(len(content)))
+	if len(header.columns) != 3 || header.columns[2] != "sc-status" {
+		t.Fatalf("Expected the columns of the last #Fields, got %v", header.columns)
+	}
+
+	content = "method\turi\nGET\t/a\n"
+	chkerr(t, ioutil.WriteFile(file, []byte(content), 0644))
+	header = newCSVHeader(&FileConfig{Codec: "tsv", CSV: &CSVCodecConfig{Header: true}})
+	header.recover(file, int64(len(content)))
+	if len(header.columns) != 2 || header.columns[1] != "uri" {
+		t.Fatalf("Expected the columns of the header row, got %v", header.columns)
+	}
+}
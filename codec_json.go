@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// JSONCodecConfig :
+// target: put the decoded object under this key, instead of merging its keys into the event
+// overwrite_keys: decoded keys replace Fields, host, path and @timestamp of the same name.
+// by default the decoded ones are dropped.
+// invalid: what to do with lines which are not a json object. message (default, send the line as message)
+// or tag (send the line as message and tag the event)
+// a decoded tags key is merged with the tags of the event in one array.
+type JSONCodecConfig struct {
+	Target        string `json:"target"`
+	OverwriteKeys bool   `json:"overwrite_keys"`
+	Invalid       string `json:"invalid"`
+}
+
+// tag added to the event if its text is not a json object
+const jsonFailureTag = "_jsonparsefailure"
+
+var errNotJSONObject = errors.New("not a json object")
+
+func (jc *JSONCodecConfig) check() error {
+	switch jc.Invalid {
+	case "", "message", "tag":
+	default:
+		return fmt.Errorf("json codec: unknown invalid %q, should be message or tag", jc.Invalid)
+	}
+	return nil
+}
+
+// decodeJSONObject decodes the members of the json object in text, in the
+// order they are written. the values are compacted json literals.
+func decodeJSONObject(text string) (keys []string, values []json.RawMessage, err error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(text)))
+	decoder.UseNumber()
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, nil, errNotJSONObject
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		var value json.RawMessage
+		if err = decoder.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		compacted := &bytes.Buffer{}
+		if err = json.Compact(compacted, value); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, token.(string))
+		values = append(values, compacted.Bytes())
+	}
+	if _, err = decoder.Token(); err != nil {
+		return nil, nil, err
+	}
+	// nothing but spaces is allowed after the object
+	if _, err = decoder.Token(); err != io.EOF {
+		return nil, nil, errNotJSONObject
+	}
+	return keys, values, nil
+}
+
+// eventKeys are the keys JsonFormat2 writes after the fields of the text
+func eventKeys(event *FileEvent) map[string]bool {
+	keys := make(map[string]bool)
+	for k := range *event.Fields {
+		keys[k] = true
+	}
+	if event.NoHostname == false {
+		keys["host"] = true
+	}
+	if event.NoPath == false {
+		keys["path"] = true
+	}
+	if event.NoTimestamp == false {
+		keys["@timestamp"] = true
+	}
+	return keys
+}
+
+// jsonFields writes the members of the json object in the text. it returns
+// false if the text is not a json object.
+func (f *formatState) jsonFields(event *FileEvent) bool {
+	jc := event.JSON
+	if jc == nil {
+		jc = &JSONCodecConfig{}
+	}
+
+	keys, values, err := decodeJSONObject(*event.Text)
+	if err != nil {
+		if jc.Invalid == "tag" {
+			f.tag(jsonFailureTag)
+		}
+		return false
+	}
+
+	if jc.Target != "" {
+		object := jsonObject(keys, values)
+		keys = []string{jc.Target}
+		values = []json.RawMessage{object}
+	}
+
+	reserved := eventKeys(event)
+	for i, k := range keys {
+		if _, ok := f.values[k]; ok {
+			continue
+		}
+		if reserved[k] {
+			if !jc.OverwriteKeys {
+				continue
+			}
+			f.overwritten[k] = true
+		}
+
+		// the raw values are what Timestamp parses
+		var s string
+		if json.Unmarshal(values[i], &s) == nil {
+			f.values[k] = s
+		} else {
+			f.values[k] = string(values[i])
+		}
+
+		// decoded tags are merged with the tags of the event, there is only one tags key
+		if k == "tags" {
+			var tags []json.RawMessage
+			if json.Unmarshal(values[i], &tags) != nil {
+				tags = []json.RawMessage{values[i]}
+			}
+			f.decodedTags = append(f.decodedTags, tags...)
+			continue
+		}
+		f.key(k)
+		f.Write(values[i])
+	}
+	return true
+}
+
+// jsonObject writes the members back to a json object, the first one of a
+// duplicated key wins
+func jsonObject(keys []string, values []json.RawMessage) json.RawMessage {
+	written := make(map[string]bool)
+	e := &encodeState{}
+	e.WriteByte('{')
+	for i, k := range keys {
+		if written[k] {
+			continue
+		}
+		written[k] = true
+		e.key(k)
+		e.Write(values[i])
+	}
+	e.WriteByte('}')
+	return e.Bytes()
+}
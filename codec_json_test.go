@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJsonCodec(t *testing.T) {
+	event := newTestEvent(`{"level": "info", "status": 200, "req": {"uri": "/a"}, "host": "app1", "type": "mine"}`)
+	event.Codec = "json"
+
+	decoded := decodeJsonFormat(t, event)
+	if decoded["level"] != "info" || decoded["status"] != float64(200) {
+		t.Errorf("Unexpected decoded fields: %v", decoded)
+	}
+	if req, _ := decoded["req"].(map[string]interface{}); req["uri"] != "/a" {
+		t.Errorf("Expected nested object kept, got %v", decoded["req"])
+	}
+	// Fields and host win by default
+	if decoded["host"] != "testhost" || decoded["type"] != "test" {
+		t.Errorf("Expected Fields and host not overwritten: %v", decoded)
+	}
+
+	event.JSON = &JSONCodecConfig{OverwriteKeys: true}
+	decoded = decodeJsonFormat(t, event)
+	if decoded["host"] != "app1" || decoded["type"] != "mine" || decoded["path"] != "/var/log/test.log" {
+		t.Errorf("Expected host and type overwritten: %v", decoded)
+	}
+
+	event.JSON = &JSONCodecConfig{Target: "app"}
+	decoded = decodeJsonFormat(t, event)
+	if app, _ := decoded["app"].(map[string]interface{}); app["level"] != "info" || app["host"] != "app1" {
+		t.Errorf("Expected the object under app, got %v", decoded["app"])
+	}
+	if _, ok := decoded["level"]; ok || decoded["host"] != "testhost" {
+		t.Errorf("Expected nothing merged into the event: %v", decoded)
+	}
+}
+
+func TestJsonCodecInvalid(t *testing.T) {
+	for _, text := range []string{`{"level": "info"`, `[1, 2]`, `{"a": 1} trailing`, `plain text`} {
+		event := newTestEvent(text)
+		event.Codec = "json"
+		event.JSON = &JSONCodecConfig{Invalid: "tag"}
+
+		decoded := decodeJsonFormat(t, event)
+		if decoded["message"] != text {
+			t.Errorf("Expected %q sent as message, got %v", text, decoded)
+		}
+		if tags, _ := decoded["tags"].([]interface{}); len(tags) != 1 || tags[0] != jsonFailureTag {
+			t.Errorf("Expected %s tag, got %v", jsonFailureTag, decoded["tags"])
+		}
+	}
+}
+
+func TestJsonCodecTimestamp(t *testing.T) {
+	tc := &TimestampConfig{Field: "ts", Layouts: []string{"UNIX_MS"}}
+	chkerr(t, tc.compile())
+
+	event := newTestEvent(`{"ts": 1420167845000, "msg": "hi"}`)
+	event.Codec = "json"
+	event.NoTimestamp = false
+	event.Timestamp = tc
+
+	decoded := decodeJsonFormat(t, event)
+	if decoded["@timestamp"] != float64(1420167845000) || decoded["ts"] != float64(1420167845000) {
+		t.Fatalf("Expected @timestamp parsed from ts, got %v", decoded)
+	}
+}
+
+func TestJsonCodecTags(t *testing.T) {
+	tc := &TimestampConfig{Field: "ts", Layouts: []string{"UNIX_MS"}}
+	chkerr(t, tc.compile())
+
+	for text, expected := range map[string][]interface{}{
+		`{"ts": "never", "tags": ["web", "prod"]}`: {"web", "prod", timestampFailureTag},
+		`{"ts": "never", "tags": "web"}`:           {"web", timestampFailureTag},
+		`{"ts": 1420167845000, "tags": ["web"]}`:   {"web"},
+	} {
+		event := newTestEvent(text)
+		event.Codec = "json"
+		event.NoTimestamp = false
+		event.Timestamp = tc
+
+		if msg := JsonFormat(event); strings.Count(msg, `"tags":`) != 1 {
+			t.Errorf("Expected one tags key, got %s", msg)
+		}
+		decoded := decodeJsonFormat(t, event)
+		if tags, _ := decoded["tags"].([]interface{}); !reflect.DeepEqual(tags, expected) {
+			t.Errorf("Expected tags %v, got %v", expected, decoded["tags"])
+		}
+	}
+}
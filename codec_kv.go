@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KVCodecConfig :
+// field_split: what separates the pairs, default " "
+// value_split: what separates the key from the value, default "="
+// quotes: characters quoting values with separators in them, default "\"". a backslash
+// escapes the quote inside a quoted value.
+// the logfmt codec is the kv codec with the defaults, and a key without value is true.
+type KVCodecConfig struct {
+	FieldSplit string `json:"field_split"`
+	ValueSplit string `json:"value_split"`
+	Quotes     string `json:"quotes"`
+}
+
+var logfmtCodec = &KVCodecConfig{FieldSplit: " ", ValueSplit: "=", Quotes: `"`}
+
+func (kc *KVCodecConfig) check() error {
+	if kc.FieldSplit == "" {
+		kc.FieldSplit = logfmtCodec.FieldSplit
+	}
+	if kc.ValueSplit == "" {
+		kc.ValueSplit = logfmtCodec.ValueSplit
+	}
+	if kc.Quotes == "" {
+		kc.Quotes = logfmtCodec.Quotes
+	}
+	if kc.FieldSplit == kc.ValueSplit {
+		return fmt.Errorf("kv codec: field_split and value_split are both %q", kc.FieldSplit)
+	}
+	return nil
+}
+
+// kvPair is a key and its value parsed from a line
+type kvPair struct {
+	key   string
+	value string
+	// a key without value_split after it
+	bare bool
+}
+
+// unquote returns the quoted value at the start of s without its quotes, and
+// the length of it in s. ok is false if the quote is not closed.
+func unquote(s string) (value string, length int, ok bool) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case quote:
+			return b.String(), i + 1, true
+		case '\\':
+			if i+1 == len(s) {
+				return "", 0, false
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			default:
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", 0, false
+}
+
+// parseKV splits text into the pairs of kc. an unclosed quote makes the rest
+// of the line the value.
+func parseKV(text string, kc *KVCodecConfig) []kvPair {
+	var pairs []kvPair
+	for s := text; s != ""; {
+		if strings.HasPrefix(s, kc.FieldSplit) {
+			s = s[len(kc.FieldSplit):]
+			continue
+		}
+
+		end := strings.Index(s, kc.FieldSplit)
+		if end < 0 {
+			end = len(s)
+		}
+		split := strings.Index(s[:end], kc.ValueSplit)
+		if split < 0 {
+			if key := strings.TrimSpace(s[:end]); key != "" {
+				pairs = append(pairs, kvPair{key: key, bare: true})
+			}
+			s = s[end:]
+			continue
+		}
+
+		key := strings.TrimSpace(s[:split])
+		s = strings.TrimLeft(s[split+len(kc.ValueSplit):], " \t")
+
+		var value string
+		if s != "" && strings.IndexByte(kc.Quotes, s[0]) >= 0 {
+			quoted, length, ok := unquote(s)
+			if ok {
+				value, s = quoted, s[length:]
+			} else {
+				value, s = s[1:], ""
+			}
+		} else {
+			end = strings.Index(s, kc.FieldSplit)
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = strings.TrimSpace(s[:end]), s[end:]
+		}
+
+		if key != "" {
+			pairs = append(pairs, kvPair{key: key, value: value})
+		}
+	}
+	return pairs
+}
+
+// kvFields writes the pairs of the line as fields, converted to their
+// CaptureTypes. keys of Fields, host, path and @timestamp are dropped.
+// it returns false if there is no pair in the line.
+func (f *formatState) kvFields(event *FileEvent) bool {
+	kc := logfmtCodec
+	// the defaults of KV are set once by check, in splitFileConfig
+	if event.Codec == "kv" && event.KV != nil {
+		kc = event.KV
+	}
+
+	reserved := eventKeys(event)
+	written := false
+	for _, pair := range parseKV(*event.Text, kc) {
+		if pair.bare {
+			if event.Codec != "logfmt" {
+				continue
+			}
+			pair.value = "true"
+		}
+		if _, ok := f.values[pair.key]; ok || reserved[pair.key] {
+			continue
+		}
+		f.typedField(event, pair.key, pair.value, event.CaptureTypes[pair.key])
+		written = true
+	}
+	return written
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLogfmtCodec(t *testing.T) {
+	event := newTestEvent(`level=info msg="request \"done\"" dur=3ms  status=200 cached host=app1 empty=`)
+	event.Codec = "logfmt"
+	event.CaptureTypes = map[string]string{"status": "integer", "cached": "boolean"}
+
+	decoded := decodeJsonFormat(t, event)
+	expected := map[string]interface{}{
+		"level":  "info",
+		"msg":    `request "done"`,
+		"dur":    "3ms",
+		"status": float64(200),
+		"cached": true,
+		"empty":  "",
+		"host":   "testhost",
+	}
+	for k, v := range expected {
+		if decoded[k] != v {
+			t.Errorf("Expected %s to be %#v, got %#v", k, v, decoded[k])
+		}
+	}
+	if _, ok := decoded["message"]; ok {
+		t.Errorf("Expected no message: %v", decoded)
+	}
+}
+
+func TestKVCodec(t *testing.T) {
+	event := newTestEvent(`user: 'bob smith', id: 7, note: 'it\'s', flag`)
+	event.Codec = "kv"
+	event.KV = &KVCodecConfig{FieldSplit: ",", ValueSplit: ":", Quotes: `'"`}
+
+	decoded := decodeJsonFormat(t, event)
+	if decoded["user"] != "bob smith" || decoded["id"] != "7" || decoded["note"] != "it's" {
+		t.Errorf("Unexpected pairs: %v", decoded)
+	}
+	// keys without value are only kept by logfmt
+	if _, ok := decoded["flag"]; ok {
+		t.Errorf("Expected flag dropped: %v", decoded)
+	}
+
+	text := "no pairs here"
+	event.Text = &text
+	decoded = decodeJsonFormat(t, event)
+	if decoded["message"] != text {
+		t.Errorf("Expected the line sent as message, got %v", decoded)
+	}
+}
+
+func TestKVCodecDefaults(t *testing.T) {
+	fileconfig := &FileConfig{Codec: "kv", KV: &KVCodecConfig{ValueSplit: ":"}}
+	chkerr(t, splitFileConfig(fileconfig))
+	if *fileconfig.KV != (KVCodecConfig{FieldSplit: " ", ValueSplit: ":", Quotes: `"`}) {
+		t.Fatalf("Expected the defaults set once in the config, got %+v", *fileconfig.KV)
+	}
+
+	event := newTestEvent(`user:"bob smith" id:7`)
+	event.Codec = fileconfig.Codec
+	event.KV = fileconfig.KV
+	decoded := decodeJsonFormat(t, event)
+	if decoded["user"] != "bob smith" || decoded["id"] != "7" {
+		t.Errorf("Unexpected pairs: %v", decoded)
+	}
+
+	fileconfig = &FileConfig{Codec: "kv", KV: &KVCodecConfig{FieldSplit: "="}}
+	if err := splitFileConfig(fileconfig); err == nil {
+		t.Errorf("Expected an error when field_split and value_split are the same")
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tag added to the event if the line is not a syslog message
+const syslogFailureTag = "_syslogparsefailure"
+
+var errNotSyslog = errors.New("not a syslog message")
+
+// syslogParam is a parameter of a structured data element, RFC 5424
+type syslogParam struct {
+	name  string
+	value string
+}
+
+type syslogElement struct {
+	id     string
+	params []syslogParam
+}
+
+// syslogMessage is a line parsed as RFC 3164 or RFC 5424. empty parts and
+// the nil value "-" of RFC 5424 are left out of the event.
+type syslogMessage struct {
+	priority  int // -1 if the line has no <PRI>, like the files written by syslogd
+	timestamp string
+	hostname  string
+	appname   string
+	procid    string
+	msgid     string
+	sd        []syslogElement
+	message   string
+}
+
+// parsePriority parses the <PRI> at the start of s, if there is one
+func parsePriority(s string) (priority int, rest string, err error) {
+	if !strings.HasPrefix(s, "<") {
+		return -1, s, nil
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 {
+		return 0, "", errNotSyslog
+	}
+	priority, err = strconv.Atoi(s[1:end])
+	if err != nil || priority > 191 {
+		return 0, "", errNotSyslog
+	}
+	return priority, s[end+1:], nil
+}
+
+// token returns the part of s before the next space and the rest after it
+func token(s string) (string, string) {
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+// parseSyslog parses text as RFC 5424 if it has a version after <PRI>, as
+// RFC 3164 otherwise
+func parseSyslog(text string) (*syslogMessage, error) {
+	priority, rest, err := parsePriority(text)
+	if err != nil {
+		return nil, err
+	}
+	if priority >= 0 && strings.HasPrefix(rest, "1 ") {
+		return parseRFC5424(priority, rest[2:])
+	}
+	return parseRFC3164(priority, rest)
+}
+
+func parseRFC5424(priority int, s string) (*syslogMessage, error) {
+	m := &syslogMessage{priority: priority}
+	var timestamp string
+	timestamp, s = token(s)
+	m.timestamp = nilValue(timestamp)
+	if m.timestamp != "" {
+		if _, err := time.Parse(time.RFC3339Nano, m.timestamp); err != nil {
+			return nil, errNotSyslog
+		}
+	}
+
+	var hostname, appname, procid, msgid string
+	hostname, s = token(s)
+	appname, s = token(s)
+	procid, s = token(s)
+	msgid, s = token(s)
+	if msgid == "" {
+		return nil, errNotSyslog
+	}
+	m.hostname, m.appname, m.procid, m.msgid = nilValue(hostname), nilValue(appname), nilValue(procid), nilValue(msgid)
+
+	if strings.HasPrefix(s, "-") {
+		s = s[1:]
+	} else {
+		var err error
+		if m.sd, s, err = parseStructuredData(s); err != nil {
+			return nil, err
+		}
+	}
+	if s != "" && s[0] != ' ' {
+		return nil, errNotSyslog
+	}
+	// a message could start with a BOM, RFC 5424 6.4
+	m.message = strings.TrimPrefix(strings.TrimPrefix(s, " "), "\ufeff")
+	return m, nil
+}
+
+// parseStructuredData parses the [id name="value" ...] elements at the start of s
+func parseStructuredData(s string) ([]syslogElement, string, error) {
+	var elements []syslogElement
+	for strings.HasPrefix(s, "[") {
+		end := strings.IndexAny(s, " ]")
+		if end < 2 {
+			return nil, "", errNotSyslog
+		}
+		element := syslogElement{id: s[1:end]}
+		s = s[end:]
+
+		for strings.HasPrefix(s, " ") {
+			s = s[1:]
+			eq := strings.Index(s, `="`)
+			if eq < 1 {
+				return nil, "", errNotSyslog
+			}
+			name := s[:eq]
+			s = s[eq+2:]
+
+			// '"', '\' and ']' are escaped with a backslash in the values
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(s); i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`"\]`, s[i+1]) >= 0 {
+					i++
+				} else if s[i] == '"' {
+					s = s[i+1:]
+					closed = true
+					break
+				}
+				value.WriteByte(s[i])
+			}
+			if !closed {
+				return nil, "", errNotSyslog
+			}
+			element.params = append(element.params, syslogParam{name: name, value: value.String()})
+		}
+
+		if !strings.HasPrefix(s, "]") {
+			return nil, "", errNotSyslog
+		}
+		s = s[1:]
+		elements = append(elements, element)
+	}
+	if elements == nil {
+		return nil, "", errNotSyslog
+	}
+	return elements, s, nil
+}
+
+// rfc3164Layout is the timestamp of RFC 3164, the day is padded with a space
+const rfc3164Layout = "Jan _2 15:04:05"
+
+func parseRFC3164(priority int, s string) (*syslogMessage, error) {
+	m := &syslogMessage{priority: priority}
+
+	// rsyslog could be set to write RFC 3339 timestamps instead
+	if len(s) > len(rfc3164Layout) && s[len(rfc3164Layout)] == ' ' {
+		if _, err := time.Parse(rfc3164Layout, s[:len(rfc3164Layout)]); err == nil {
+			m.timestamp, s = s[:len(rfc3164Layout)], s[len(rfc3164Layout)+1:]
+		}
+	}
+	if m.timestamp == "" {
+		timestamp, rest := token(s)
+		if _, err := time.Parse(time.RFC3339Nano, timestamp); err != nil {
+			return nil, errNotSyslog
+		}
+		m.timestamp, s = timestamp, rest
+	}
+
+	m.hostname, s = token(s)
+	if m.hostname == "" {
+		return nil, errNotSyslog
+	}
+
+	// TAG[pid]: message, the tag is optional
+	if colon := strings.Index(s, ": "); colon > 0 && !strings.ContainsAny(s[:colon], " ") {
+		tag := s[:colon]
+		if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+			m.appname, m.procid = tag[:open], tag[open+1:len(tag)-1]
+		} else {
+			m.appname = tag
+		}
+		s = s[colon+2:]
+	}
+	m.message = s
+	return m, nil
+}
+
+// syslogFields writes the parts of the syslog message in the text. it returns
+// false if the text is not a syslog message.
+func (f *formatState) syslogFields(event *FileEvent) bool {
+	m, err := parseSyslog(*event.Text)
+	if err != nil {
+		f.tag(syslogFailureTag)
+		return false
+	}
+
+	reserved := eventKeys(event)
+	field := func(name string, value string, fieldType string) {
+		if _, ok := f.values[name]; ok || reserved[name] || value == "" {
+			return
+		}
+		if captureType, ok := event.CaptureTypes[name]; ok {
+			fieldType = captureType
+		}
+		f.typedField(event, name, value, fieldType)
+	}
+
+	if m.priority >= 0 {
+		field("priority", strconv.Itoa(m.priority), "integer")
+		field("facility", strconv.Itoa(m.priority/8), "integer")
+		field("severity", strconv.Itoa(m.priority%8), "integer")
+	}
+	field("timestamp", m.timestamp, "")
+	field("hostname", m.hostname, "")
+	field("appname", m.appname, "")
+	field("procid", m.procid, "")
+	field("msgid", m.msgid, "")
+
+	if len(m.sd) > 0 && !reserved["structured_data"] {
+		f.key("structured_data")
+		f.Write(syslogStructuredData(m.sd))
+	}
+
+	field("message", m.message, "")
+	return true
+}
+
+// syslogStructuredData encodes the structured data elements as a json object.
+// elements with the same id are merged, and a param given more than once, like
+// the ip of the origin element, becomes an array of its values.
+func syslogStructuredData(sd []syslogElement) []byte {
+	var ids []string
+	names := make(map[string][]string)
+	values := make(map[string]map[string][]string)
+	for _, element := range sd {
+		if _, ok := values[element.id]; !ok {
+			ids = append(ids, element.id)
+			values[element.id] = make(map[string][]string)
+		}
+		for _, param := range element.params {
+			if _, ok := values[element.id][param.name]; !ok {
+				names[element.id] = append(names[element.id], param.name)
+			}
+			values[element.id][param.name] = append(values[element.id][param.name], param.value)
+		}
+	}
+
+	e := &encodeState{}
+	e.WriteByte('{')
+	for _, id := range ids {
+		e.key(id)
+		params := &encodeState{}
+		params.WriteByte('{')
+		for _, name := range names[id] {
+			params.key(name)
+			if vs := values[id][name]; len(vs) == 1 {
+				params.quoted(vs[0])
+			} else {
+				params.WriteByte('[')
+				for i, v := range vs {
+					if i > 0 {
+						params.WriteByte(',')
+					}
+					params.quoted(v)
+				}
+				params.WriteByte(']')
+			}
+		}
+		params.WriteByte('}')
+		e.Write(params.Bytes())
+	}
+	e.WriteByte('}')
+	return e.Bytes()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSyslogCodecRFC3164(t *testing.T) {
+	event := newTestEvent("<34>Oct  1 22:14:15 mymachine su[1234]: 'su root' failed for lonvick on /dev/pts/8")
+	event.Codec = "syslog"
+
+	decoded := decodeJsonFormat(t, event)
+	expected := map[string]interface{}{
+		"priority":  float64(34),
+		"facility":  float64(4),
+		"severity":  float64(2),
+		"timestamp": "Oct  1 22:14:15",
+		"hostname":  "mymachine",
+		"appname":   "su",
+		"procid":    "1234",
+		"message":   "'su root' failed for lonvick on /dev/pts/8",
+	}
+	for k, v := range expected {
+		if decoded[k] != v {
+			t.Errorf("Expected %s to be %#v, got %#v", k, v, decoded[k])
+		}
+	}
+
+	// the files of syslogd have no priority
+	text := "Jan 12 06:30:00 web1 kernel: eth0: link up"
+	event.Text = &text
+	decoded = decodeJsonFormat(t, event)
+	if _, ok := decoded["priority"]; ok || decoded["appname"] != "kernel" || decoded["message"] != "eth0: link up" {
+		t.Errorf("Unexpected fields: %v", decoded)
+	}
+}
+
+func TestSyslogCodecRFC5424(t *testing.T) {
+	event := newTestEvent(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="App\"lication"][origin ip="10.0.0.1"] An application event`)
+	event.Codec = "syslog"
+
+	decoded := decodeJsonFormat(t, event)
+	if decoded["facility"] != float64(20) || decoded["severity"] != float64(5) || decoded["msgid"] != "ID47" {
+		t.Errorf("Unexpected fields: %v", decoded)
+	}
+	if _, ok := decoded["procid"]; ok {
+		t.Errorf("Expected the nil procid left out, got %v", decoded["procid"])
+	}
+	sd, _ := decoded["structured_data"].(map[string]interface{})
+	example, _ := sd["exampleSDID@32473"].(map[string]interface{})
+	origin, _ := sd["origin"].(map[string]interface{})
+	if example["eventSource"] != `App"lication` || origin["ip"] != "10.0.0.1" {
+		t.Errorf("Unexpected structured data: %v", decoded["structured_data"])
+	}
+	if decoded["message"] != "An application event" || decoded["timestamp"] != "2003-10-11T22:14:15.003Z" {
+		t.Errorf("Unexpected fields: %v", decoded)
+	}
+}
+
+func TestSyslogCodecInvalid(t *testing.T) {
+	for _, text := range []string{"not syslog at all", "<999>Oct  1 22:14:15 host x", `<1>1 2003-10-11T22:14:15Z host app - - [broken`} {
+		event := newTestEvent(text)
+		event.Codec = "syslog"
+
+		decoded := decodeJsonFormat(t, event)
+		if decoded["message"] != text {
+			t.Errorf("Expected %q sent as message, got %v", text, decoded)
+		}
+		if tags, _ := decoded["tags"].([]interface{}); len(tags) != 1 || tags[0] != syslogFailureTag {
+			t.Errorf("Expected %s tag, got %v", syslogFailureTag, decoded["tags"])
+		}
+	}
+}
+
+func TestSyslogCodecDuplicateStructuredData(t *testing.T) {
+	event := newTestEvent(`<165>1 2003-10-11T22:14:15.003Z host app - - [origin ip="10.0.0.1" ip="10.0.0.2"][meta seq="1"][origin software="x"] event`)
+	event.Codec = "syslog"
+
+	decoded := decodeJsonFormat(t, event)
+	sd, _ := decoded["structured_data"].(map[string]interface{})
+	origin, _ := sd["origin"].(map[string]interface{})
+	ips, _ := origin["ip"].([]interface{})
+	if len(ips) != 2 || ips[0] != "10.0.0.1" || ips[1] != "10.0.0.2" || origin["software"] != "x" {
+		t.Errorf("Expected the origin elements merged, got %v", decoded["structured_data"])
+	}
+	if meta, _ := sd["meta"].(map[string]interface{}); meta["seq"] != "1" {
+		t.Errorf("Unexpected structured data: %v", decoded["structured_data"])
+	}
+}
+
+func TestSyslogCodecTimestamp(t *testing.T) {
+	fileconfig := &FileConfig{Codec: "syslog"}
+	chkerr(t, splitFileConfig(fileconfig))
+
+	event := newTestEvent(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 - event`)
+	event.Codec = fileconfig.Codec
+	event.NoTimestamp = false
+	event.Timestamp = fileconfig.Timestamp
+
+	decoded := decodeJsonFormat(t, event)
+	if decoded["@timestamp"] != float64(1065910455003) {
+		t.Errorf("Expected @timestamp parsed from the message, got %v", decoded["@timestamp"])
+	}
+	if _, ok := decoded["tags"]; ok {
+		t.Errorf("Unexpected tags %v", decoded["tags"])
+	}
+
+	// RFC 3164 timestamps have no year
+	text := "<34>Oct  1 22:14:15 mymachine su: failed"
+	event.Text = &text
+	decoded = decodeJsonFormat(t, event)
+	if _, ok := decoded["tags"]; ok {
+		t.Errorf("Expected the RFC 3164 timestamp parsed, got tags %v", decoded["tags"])
+	}
+}
+
+func TestSyslogCodecInvalidTimestamp(t *testing.T) {
+	fileconfig := &FileConfig{Codec: "syslog"}
+	chkerr(t, splitFileConfig(fileconfig))
+
+	event := newTestEvent("not syslog at all")
+	event.Codec = fileconfig.Codec
+	event.NoTimestamp = false
+	event.Timestamp = fileconfig.Timestamp
+
+	// only the syslog failure is tagged, there is no timestamp to parse
+	decoded := decodeJsonFormat(t, event)
+	if tags, _ := decoded["tags"].([]interface{}); len(tags) != 1 || tags[0] != syslogFailureTag {
+		t.Errorf("Expected only the %s tag, got %v", syslogFailureTag, decoded["tags"])
+	}
+	if _, ok := decoded["@timestamp"]; !ok {
+		t.Errorf("Expected a @timestamp, got %v", decoded)
+	}
+}
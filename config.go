@@ -22,23 +22,73 @@ var defaultConfig = &struct {
 }
 
 // Config is parsed from a json file, including files and kakfa config
+// Listeners: network syslog listeners, see ListenerConfig
+// Journald: journald inputs, see JournaldConfig
+// Output: list of output backends, default ["kafka"]
+// RegistryFile: where the positions of the files are saved, default .logstash-forwarder in the working directory
+// RegistryNamespace: appended to RegistryFile, so agents with different configs have their own registries
+// RegistryCleanup: remove the states of the files not harvested anymore from the registry, see RegistryCleanupConfig
 type Config struct {
-	Files []FileConfig `json:"files"`
-	Kafka KafkaConfig  `json:"kafka"`
+	Files             []FileConfig          `json:"files"`
+	Listeners         []ListenerConfig      `json:"listeners"`
+	Journald          []JournaldConfig      `json:"journald"`
+	Kafka             KafkaConfig           `json:"kafka"`
+	Network           NetworkConfig         `json:"network"`
+	Receiver          ReceiverConfig        `json:"receiver"`
+	DiskQueue         DiskQueueConfig       `json:"disk_queue"`
+	Output            []string              `json:"output"`
+	RegistryFile      string                `json:"registry_file"`
+	RegistryNamespace string                `json:"registry_namespace"`
+	RegistryCleanup   RegistryCleanupConfig `json:"registry_cleanup"`
 }
 
 // FileConfig :
-// Paths: list of paths
+// Paths: list of paths, globs. ** matches any number of directories, "/var/log/app/**/*.log"
 // Fields: a dict, add this dict to the whole event
 // FieldNames: split the message to FieldsNames
+// FieldTypes: convert the split fields, one type for each of FieldNames.
+// string, integer, float, boolean, or timestamp:<go layout> which is converted to epoch millis.
+// ConversionFailure: what to do with a value which could not be converted.
+// keep (default, keep it as string), drop (drop the field) or tag (keep it as string and tag the event)
 // ExactMatch: if set it to false, "error errormsg abcd xyz" could be splited to
 // logleve and logmessage. if set to true, could not splitted, because
 //splited parts do not match.
+// Pattern: a regexp with named groups, each matched group becomes a field. it could not be used with FieldNames.
+// if ExactMatch is true, the pattern must match the whole line.
+// Grok: like Pattern, but with grok references, "%{IP:client.ip} \[%{HTTPDATE:time}\]". %{NUMBER:bytes:int} sets the CaptureTypes of bytes.
+// GrokPatternFiles: files of "NAME regexp" lines, adding to or overriding the built-in patterns
+// CaptureTypes: FieldTypes of the named groups, {"status": "integer"}. groups not listed are strings.
+// PatternFailure: what to do with lines not matching the pattern. message (default, send the line as message)
+// or tag (send the line as message and tag the event)
+// Codec: how the lines are decoded. plain (default), json, which merges the keys of the
+// json object in each line into the event, logfmt or kv, which write the key=value pairs of
+// each line as fields, or csv, tsv and w3c, which write the values of each line with the names
+// of their columns, or syslog, which writes the priority, facility, severity, timestamp, hostname,
+// appname, procid, msgid, structured_data and message of RFC 3164 and RFC 5424 lines, and
+// parses @timestamp from the timestamp of the lines if Timestamp is not set.
+// it could not be used with Pattern, only csv codecs take FieldNames.
+// JSON: options of the json codec, see JSONCodecConfig
+// KV: options of the kv codec, see KVCodecConfig. CaptureTypes sets the FieldTypes of the keys.
+// CSV: options of the csv, tsv and w3c codecs, see CSVCodecConfig. CaptureTypes sets the FieldTypes
+// of the columns, FieldTypes are the ones of FieldNames.
+// Container: unwrap the lines of container logs, docker (json-file driver), cri (containerd, cri-o) or auto.
+// partial lines are joined, the time of the lines is @timestamp, and the id, name, image and labels of the
+// container, from config.v2.json for docker, are added to the fields with the stream.
+// Kubernetes: discover the pod logs of a kubernetes node, see KubernetesConfig. Paths are the pod logs if empty.
+// ExcludeFiles: files matching Paths which are not harvested. globs, matched against the path, or against the
+// name of the file if they have no directory, "*.gz", or regexps between slashes, "/debug|trace/"
+// IncludeLines: regexps, only the lines matching one of them are sent. with Multiline, the merged events
+// ExcludeLines: regexps, the lines matching one of them are dropped, after IncludeLines
+// Decompress: harvest the .gz, .bz2 and .zst files matching Paths decompressed. each archive is read once from
+// start to end, the registrar records when it is done so it is not read again
+// Timestamp: parse @timestamp from one of the FieldNames or Pattern groups instead of using the current time
 // TODO
 type FileConfig struct {
 	Paths                         []string          `json:"paths"`
 	Fields                        map[string]string `json:"fields"`
 	FieldNames                    []string          `json:"fieldnames"`
+	FieldTypes                    []string          `json:"fieldtypes"`
+	ConversionFailure             string
 	ExactMatch                    bool
 	Delimiter                     string
 	MaxBytes                      int
@@ -53,6 +103,28 @@ type FileConfig struct {
 	NoTimestamp                   bool
 	HarvestFromBeginningOnNewFile bool
 	Multiline                     *MultilineConfig `json:"multiline"`
+	Timestamp                     *TimestampConfig `json:"timestamp"`
+	Pattern                       string           `json:"pattern"`
+	PatternRegexp                 *regexp.Regexp
+	Grok                          string   `json:"grok"`
+	GrokPatternFiles              []string `json:"grok_pattern_files"`
+	CaptureTypes                  map[string]string
+	CaptureNames                  map[string]string `json:"-"` // fields of the grok groups not named after them
+	PatternFailure                string
+	Codec                         string            `json:"codec"`
+	JSON                          *JSONCodecConfig  `json:"json"`
+	KV                            *KVCodecConfig    `json:"kv"`
+	CSV                           *CSVCodecConfig   `json:"csv"`
+	Container                     string            `json:"container"`
+	Kubernetes                    *KubernetesConfig `json:"kubernetes"`
+	ExcludeFiles                  []string          `json:"exclude_files"`
+	IncludeLines                  []string          `json:"include_lines"`
+	ExcludeLines                  []string          `json:"exclude_lines"`
+	Decompress                    bool              `json:"decompress"`
+	excludeFiles                  []*regexp.Regexp
+	includeLines                  []*regexp.Regexp
+	excludeLines                  []*regexp.Regexp
+	id                            string // files/N, listeners/N or journald/N, set by SplitConf
 }
 
 // MultilineConfig :
@@ -108,13 +180,53 @@ func MergeConfig(to *Config, from Config) (err error) {
 	to.Kafka.KeepAlive = from.Kafka.KeepAlive
 	to.Kafka.RefreshFrequency = from.Kafka.RefreshFrequency
 	to.Kafka.Key = from.Kafka.Key
+	to.Kafka.RetryBackoff = from.Kafka.RetryBackoff
 	if from.Kafka.Key != nil {
 		to.Kafka.KeyTemplate = template.Must(template.New("key").Parse(*from.Kafka.Key))
 	} else {
 		to.Kafka.KeyTemplate = nil
 	}
 
+	to.Network.Servers = append(to.Network.Servers, from.Network.Servers...)
+	if from.Network.SSLCertificate != "" {
+		to.Network.SSLCertificate = from.Network.SSLCertificate
+	}
+	if from.Network.SSLKey != "" {
+		to.Network.SSLKey = from.Network.SSLKey
+	}
+	if from.Network.SSLCA != "" {
+		to.Network.SSLCA = from.Network.SSLCA
+	}
+	if from.Network.Timeout != 0 {
+		to.Network.Timeout = from.Network.Timeout
+	}
+	if from.Network.WindowSize != 0 {
+		to.Network.WindowSize = from.Network.WindowSize
+	}
+	if from.Network.CompressionLevel != 0 {
+		to.Network.CompressionLevel = from.Network.CompressionLevel
+	}
+
+	if from.Receiver.Listen != "" {
+		to.Receiver = from.Receiver
+	}
+	if from.DiskQueue.Path != "" {
+		to.DiskQueue = from.DiskQueue
+	}
+	if from.RegistryFile != "" {
+		to.RegistryFile = from.RegistryFile
+	}
+	if from.RegistryNamespace != "" {
+		to.RegistryNamespace = from.RegistryNamespace
+	}
+	if from.RegistryCleanup != (RegistryCleanupConfig{}) {
+		to.RegistryCleanup = from.RegistryCleanup
+	}
+
 	to.Files = append(to.Files, from.Files...)
+	to.Listeners = append(to.Listeners, from.Listeners...)
+	to.Journald = append(to.Journald, from.Journald...)
+	to.Output = append(to.Output, from.Output...)
 
 	return nil
 }
@@ -187,11 +299,41 @@ func LoadConfig(path string) (config Config, err error) {
 			emit("Failed to get hostname")
 		}
 	}
+
+	for k := range config.Listeners {
+		if config.Listeners[k].Codec == "" {
+			config.Listeners[k].Codec = "syslog"
+		}
+
+		if config.Listeners[k].MaxBytes == 0 {
+			config.Listeners[k].MaxBytes = 1024 * 1024
+		}
+
+		if config.Listeners[k].Multiline != nil {
+			config.Listeners[k].Multiline.MatchRegexp, err = regexp.Compile(config.Listeners[k].Multiline.Match)
+			if err != nil {
+				emit("Could not compile '%s'. Error was: %s\n", config.Listeners[k].Multiline.Match, err)
+				return
+			}
+			config.Listeners[k].Multiline.Leader = config.Listeners[k].Multiline.What == "leader"
+		}
+	}
+
+	for k := range config.Journald {
+		if err = journaldDefaults(&config.Journald[k]); err != nil {
+			emit("%s\n", err)
+			return
+		}
+	}
 	return
 }
 
 // FinalizeConfig set default config
 func FinalizeConfig(config *Config) {
+	if config.Network.Timeout == 0 {
+		config.Network.Timeout = defaultConfig.netTimeout
+	}
+	config.Network.timeout = time.Duration(config.Network.Timeout) * time.Second
 }
 
 // StripComments remove comments from json config file
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// containerLog unwraps the lines of a container log file, written by the
+// docker json-file driver or by a CRI runtime like containerd. lines split
+// by the runtime are joined again.
+type containerLog struct {
+	format   string // docker, cri or auto
+	maxBytes int
+	// Fields with the container metadata and the stream, by stream
+	fields map[string]*map[string]string
+	base   map[string]string
+
+	// partial lines by stream, the streams of a container are interleaved
+	partial map[string]*partialLine
+	// bytes read, and bytes before the first partial line not completed yet
+	read      int64
+	committed int64
+
+	// time and stream of the last line unwrapped
+	time   time.Time
+	stream string
+}
+
+// partialLine are the parts of a line split by the runtime
+type partialLine struct {
+	parts []string
+	len   int
+	start int64 // where the first part is in the bytes read
+}
+
+// dockerLine is a line of the docker json-file driver
+type dockerLine struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+// dockerConfig is the part of config.v2.json of a docker container we need
+type dockerConfig struct {
+	ID     string `json:"ID"`
+	Name   string `json:"Name"`
+	Config struct {
+		Image  string            `json:"Image"`
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+}
+
+// /var/log/containers/<pod>_<namespace>_<container>-<id>.log, the links kubelet makes to CRI logs
+var criLogName = regexp.MustCompile(`^[^_]+_[^_]+_(.+)-([0-9a-f]{64})\.log$`)
+
+func newContainerLog(fileconfig *FileConfig, path string) *containerLog {
+	if fileconfig.Container == "" {
+		return nil
+	}
+
+	c := &containerLog{
+		format:   fileconfig.Container,
+		maxBytes: fileconfig.MaxBytes,
+		fields:   make(map[string]*map[string]string),
+		base:     make(map[string]string),
+		partial:  make(map[string]*partialLine),
+	}
+	for k, v := range containerMetadata(path) {
+		c.base[k] = v
+	}
+	if fileconfig.Kubernetes != nil {
+		for k, v := range fileconfig.Kubernetes.podMetadata(path) {
+			c.base[k] = v
+		}
+	}
+	// the configured Fields win
+	for k, v := range fileconfig.Fields {
+		c.base[k] = v
+	}
+	return c
+}
+
+// containerMetadata reads the id, name, image and labels of the container
+// whose log is path, from the config.v2.json next to docker logs, or from the
+// name of kubelet's links to CRI logs
+func containerMetadata(path string) map[string]string {
+	metadata := make(map[string]string)
+
+	if m := criLogName.FindStringSubmatch(filepath.Base(path)); m != nil {
+		metadata["container_name"] = m[1]
+		metadata["container_id"] = m[2]
+		return metadata
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(path), "config.v2.json"))
+	if err != nil {
+		return metadata
+	}
+	var config dockerConfig
+	if err = json.Unmarshal(data, &config); err != nil {
+		emit("Could not read the container config of %s: %s\n", path, err)
+		return metadata
+	}
+	metadata["container_id"] = config.ID
+	metadata["container_name"] = strings.TrimPrefix(config.Name, "/")
+	metadata["container_image"] = config.Config.Image
+	for k, v := range config.Config.Labels {
+		metadata["container_label_"+k] = v
+	}
+	return metadata
+}
+
+// streamFields returns the Fields of the events of stream
+func (c *containerLog) streamFields(stream string) *map[string]string {
+	if fields, ok := c.fields[stream]; ok {
+		return fields
+	}
+	fields := make(map[string]string, len(c.base)+1)
+	for k, v := range c.base {
+		fields[k] = v
+	}
+	if _, ok := fields["stream"]; !ok && stream != "" {
+		fields["stream"] = stream
+	}
+	c.fields[stream] = &fields
+	return &fields
+}
+
+// parseDocker parses a line of the json-file driver. lines longer than 16k
+// are split by docker, all parts but the last one have no newline.
+func parseDocker(line string) (text string, stream string, t time.Time, partial bool, ok bool) {
+	var l dockerLine
+	if json.Unmarshal([]byte(line), &l) != nil {
+		return "", "", time.Time{}, false, false
+	}
+	if !strings.HasSuffix(l.Log, "\n") {
+		return l.Log, l.Stream, l.Time, true, true
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(l.Log, "\n"), "\r"), l.Stream, l.Time, false, true
+}
+
+// parseCRI parses a line of the CRI format, "<time> <stream> <P|F> <log>".
+// P is a partial line, F the full or the last part of one.
+func parseCRI(line string) (text string, stream string, t time.Time, partial bool, ok bool) {
+	parts := strings.SplitN(line, " ", 4)
+	if len(parts) < 3 {
+		return "", "", time.Time{}, false, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return "", "", time.Time{}, false, false
+	}
+	if len(parts) == 4 {
+		text = parts[3]
+	}
+	// the tag could have more flags after the P or F, separated by ':'
+	tag := strings.SplitN(parts[2], ":", 2)[0]
+	switch tag {
+	case "P":
+		return text, parts[1], t, true, true
+	case "F":
+		return text, parts[1], t, false, true
+	}
+	return "", "", time.Time{}, false, false
+}
+
+// unwrap returns the log text of line. ok is false for partial lines, lines
+// in neither format are kept as they are. size is how far the offset can
+// move, up to the first partial line of the streams not completed yet, the
+// harvest resumes from there.
+func (c *containerLog) unwrap(line string, bytesread int) (text string, size int, ok bool) {
+	format := c.format
+	if format == "auto" {
+		format = "cri"
+		if strings.HasPrefix(line, "{") {
+			format = "docker"
+		}
+	}
+
+	var partial, parsed bool
+	var stream string
+	var t time.Time
+	if format == "docker" {
+		text, stream, t, partial, parsed = parseDocker(line)
+	} else {
+		text, stream, t, partial, parsed = parseCRI(line)
+	}
+	if !parsed {
+		text, stream, t = line, "", time.Time{}
+	}
+
+	c.read += int64(bytesread)
+	p := c.partial[stream]
+	if partial {
+		if p == nil {
+			p = &partialLine{start: c.read - int64(bytesread)}
+			c.partial[stream] = p
+		}
+		// what is beyond MaxBytes is dropped anyway
+		if c.maxBytes == 0 || p.len < c.maxBytes {
+			p.parts = append(p.parts, text)
+			p.len += len(text)
+		}
+		return "", 0, false
+	}
+	if p != nil {
+		text = strings.Join(append(p.parts, text), "")
+		delete(c.partial, stream)
+	}
+	if c.maxBytes > 0 && len(text) > c.maxBytes {
+		text = truncateUTF8(text, c.maxBytes)
+	}
+
+	committed := c.read
+	for _, p := range c.partial {
+		if p.start < committed {
+			committed = p.start
+		}
+	}
+	size = int(committed - c.committed)
+	c.committed = committed
+	c.time, c.stream = t, stream
+	return text, size, true
+}
+
+// truncateUTF8 cuts s to at most n bytes, without splitting a rune
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContainerLogDocker(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	id := "3f4e5d6c7b8a"
+	dir := filepath.Join(tmpdir, id)
+	chkerr(t, os.Mkdir(dir, 0755))
+	config := `{"ID": "3f4e5d6c7b8a", "Name": "/web", "Config": {"Image": "nginx:1.19", "Labels": {"app": "shop"}}}`
+	chkerr(t, ioutil.WriteFile(filepath.Join(dir, "config.v2.json"), []byte(config), 0644))
+
+	fileconfig := &FileConfig{Container: "docker", Fields: map[string]string{"type": "docker"}}
+	c := newContainerLog(fileconfig, filepath.Join(dir, id+"-json.log"))
+
+	lines := []string{
+		`{"log":"first part, ","stream":"stderr","time":"2019-03-01T10:00:00.5Z"}`,
+		`{"log":"second part\n","stream":"stderr","time":"2019-03-01T10:00:01.25Z"}`,
+	}
+	if _, _, ok := c.unwrap(lines[0], len(lines[0])+1); ok {
+		t.Fatalf("Expected the partial line to be held")
+	}
+	text, size, ok := c.unwrap(lines[1], len(lines[1])+1)
+	if !ok || text != "first part, second part" || size != len(lines[0])+len(lines[1])+2 {
+		t.Fatalf("Unexpected joined line %q of %d bytes", text, size)
+	}
+
+	source := "/var/lib/docker/containers/x/x-json.log"
+	hostname := "testhost"
+	event := &FileEvent{Source: &source, Text: &text, Hostname: &hostname, Fields: c.streamFields(c.stream), Time: c.time}
+	decoded := decodeJsonFormat(t, event)
+	expected := map[string]interface{}{
+		"message":             "first part, second part",
+		"stream":              "stderr",
+		"container_id":        id,
+		"container_name":      "web",
+		"container_image":     "nginx:1.19",
+		"container_label_app": "shop",
+		"type":                "docker",
+		"@timestamp":          float64(1551434401250),
+	}
+	for k, v := range expected {
+		if decoded[k] != v {
+			t.Errorf("Expected %s to be %#v, got %#v", k, v, decoded[k])
+		}
+	}
+}
+
+func TestContainerLogCRI(t *testing.T) {
+	id := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	c := newContainerLog(&FileConfig{Container: "auto"}, "/var/log/containers/web-1_shop_nginx-"+id+".log")
+	if c.base["container_id"] != id || c.base["container_name"] != "nginx" {
+		t.Errorf("Unexpected metadata from the file name: %v", c.base)
+	}
+
+	if _, _, ok := c.unwrap("2016-10-06T00:17:09.669794202Z stdout P a long ", 47); ok {
+		t.Fatalf("Expected the partial line to be held")
+	}
+	text, size, ok := c.unwrap("2016-10-06T00:17:09.669794203Z stdout F line", 45)
+	if !ok || text != "a long line" || size != 92 || c.stream != "stdout" || c.time.Nanosecond() != 669794203 {
+		t.Fatalf("Unexpected joined line %q of %d bytes", text, size)
+	}
+
+	// an empty full line has no log after the tag
+	if text, _, ok = c.unwrap("2016-10-06T00:17:10Z stderr F", 30); !ok || text != "" || c.stream != "stderr" {
+		t.Fatalf("Unexpected empty line %q", text)
+	}
+	// lines in neither format are kept
+	if text, _, ok = c.unwrap("not a container log", 20); !ok || text != "not a container log" {
+		t.Fatalf("Unexpected line %q", text)
+	}
+}
+
+func TestContainerLogInterleavedStreams(t *testing.T) {
+	c := newContainerLog(&FileConfig{Container: "cri", MaxBytes: 14}, "/var/log/pods/x.log")
+
+	lines := []string{
+		"2016-10-06T00:17:09Z stdout P out ",
+		"2016-10-06T00:17:09Z stderr P err ",
+		"2016-10-06T00:17:10Z stderr F line",
+		"2016-10-06T00:17:10Z stdout F line, in é",
+	}
+	for _, line := range lines[:2] {
+		if _, _, ok := c.unwrap(line, len(line)+1); ok {
+			t.Fatalf("Expected the partial line to be held: %s", line)
+		}
+	}
+	// the offset can not move past the partial line of stdout
+	text, size, ok := c.unwrap(lines[2], len(lines[2])+1)
+	if !ok || text != "err line" || c.stream != "stderr" || size != 0 {
+		t.Fatalf("Unexpected stderr line %q of %d bytes on %s", text, size, c.stream)
+	}
+	// truncated to MaxBytes, but not inside é
+	text, size, ok = c.unwrap(lines[3], len(lines[3])+1)
+	all := len(lines[0]) + len(lines[1]) + len(lines[2]) + len(lines[3]) + 4
+	if !ok || text != "out line, in " || c.stream != "stdout" || size != all {
+		t.Fatalf("Unexpected stdout line %q of %d bytes on %s", text, size, c.stream)
+	}
+
+	if s := truncateUTF8("abcé", 4); s != "abc" {
+		t.Errorf("Expected é to be dropped whole, got %q", s)
+	}
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiskQueueConfig is the config of the disk queue between spooler and
+// publisher. batches are stored on disk before they are handed to the
+// registrar, so they are replayed after a restart or an output outage.
+// the events of the receiver are acked to their forwarders once they are
+// stored in the queue too, before the output published them.
+type DiskQueueConfig struct {
+	Path          string `json:"path"`           // directory of the queue, the queue is disabled if empty
+	SegmentSize   int64  `json:"segment_size"`   // bytes, a new segment file is started after this size. default 64MB
+	MaxSize       int64  `json:"max_size"`       // bytes, the spooler blocks while the queue is this large. default 1GB
+	Fsync         string `json:"fsync"`          // always, interval or never. default always
+	FsyncInterval string `json:"fsync_interval"` // string, 100ms, 1s, default 1s. used if fsync is interval
+}
+
+const (
+	diskQueueSegmentSize = 64 << 20
+	diskQueueMaxSize     = 1 << 30
+	diskQueueCursorFile  = "cursor"
+	diskQueueSegmentExt  = ".seg"
+)
+
+var errCorruptRecord = errors.New("corrupt record")
+
+// queuePosition is a position in the queue, the cursor file stores the
+// position of the first unacked batch
+type queuePosition struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+type DiskQueue struct {
+	conf         *DiskQueueConfig
+	configs      map[string]*FileConfig // configs of the inputs by id, re-attached to the replayed events
+	segmentSize  int64
+	maxSize      int64
+	fsync        string
+	syncInterval time.Duration
+
+	mutex        sync.Mutex
+	cond         *sync.Cond // broadcasts on new records and on freed space
+	segments     []uint64   // ids of segment files on disk, ascending
+	segmentSizes map[uint64]int64
+	size         int64
+
+	writer      *os.File
+	writeSeg    uint64
+	writeOffset int64
+	dirty       bool
+
+	reader    *os.File
+	readSeg   uint64
+	cursor    queuePosition
+	published []queuePosition // end positions of published but unacked batches
+}
+
+func NewDiskQueue(conf *DiskQueueConfig, configs map[string]*FileConfig) (*DiskQueue, error) {
+	q := &DiskQueue{
+		conf:         conf,
+		configs:      configs,
+		segmentSize:  conf.SegmentSize,
+		maxSize:      conf.MaxSize,
+		fsync:        strings.ToLower(conf.Fsync),
+		syncInterval: MustParseInterval(conf.FsyncInterval, time.Second*1),
+		segmentSizes: make(map[uint64]int64),
+	}
+	q.cond = sync.NewCond(&q.mutex)
+	if q.segmentSize <= 0 {
+		q.segmentSize = diskQueueSegmentSize
+	}
+	if q.maxSize <= 0 {
+		q.maxSize = diskQueueMaxSize
+	}
+	// the queue could only shrink by removing whole segments
+	if q.segmentSize > q.maxSize/4 {
+		q.segmentSize = q.maxSize / 4
+	}
+	switch q.fsync {
+	case "":
+		q.fsync = "always"
+	case "always", "interval", "never":
+	default:
+		return nil, fmt.Errorf("unknown fsync policy: %q", conf.Fsync)
+	}
+
+	if err := os.MkdirAll(conf.Path, 0755); err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(conf.Path)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), diskQueueSegmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), diskQueueSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.segments = append(q.segments, id)
+		q.segmentSizes[id] = entry.Size()
+		q.size += entry.Size()
+	}
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i] < q.segments[j] })
+
+	if buf, err := ioutil.ReadFile(filepath.Join(conf.Path, diskQueueCursorFile)); err == nil {
+		if err := json.Unmarshal(buf, &q.cursor); err != nil {
+			emit("disk queue: ignoring corrupt cursor file: %s\n", err)
+			q.cursor = queuePosition{}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// segments before the cursor are fully acked
+	q.removeSegments(q.cursor.Segment)
+	if len(q.segments) > 0 && q.segments[0] > q.cursor.Segment {
+		q.cursor = queuePosition{Segment: q.segments[0]}
+	}
+
+	// never append to segments of a previous run, their tail may be torn
+	q.writeSeg = q.cursor.Segment + 1
+	if len(q.segments) > 0 && q.segments[len(q.segments)-1] >= q.writeSeg {
+		q.writeSeg = q.segments[len(q.segments)-1] + 1
+	}
+	if len(q.segments) == 0 {
+		q.cursor = queuePosition{Segment: q.writeSeg}
+	}
+
+	if q.size > 0 {
+		emit("disk queue: %d bytes in %d segments to replay from %s\n", q.size, len(q.segments), conf.Path)
+	}
+	return q, nil
+}
+
+func (q *DiskQueue) segmentPath(id uint64) string {
+	return filepath.Join(q.conf.Path, fmt.Sprintf("%020d%s", id, diskQueueSegmentExt))
+}
+
+// removeSegments deletes the segments before segment id, q.mutex must be held
+func (q *DiskQueue) removeSegments(id uint64) {
+	for len(q.segments) > 0 && q.segments[0] < id {
+		seg := q.segments[0]
+		if err := os.Remove(q.segmentPath(seg)); err != nil && !os.IsNotExist(err) {
+			emit("disk queue: failed removing segment %d: %s\n", seg, err)
+		}
+		q.size -= q.segmentSizes[seg]
+		delete(q.segmentSizes, seg)
+		q.segments = q.segments[1:]
+	}
+}
+
+// Run stores batches from input on disk, hands them to the registrar once
+// stored, and publishes them from disk in order.
+func (q *DiskQueue) Run(input chan []*FileEvent, registrar chan []*FileEvent, p Publisher) {
+	go q.publishLoop(p)
+	go q.ackLoop(p)
+	if q.fsync == "interval" {
+		go q.syncLoop()
+	}
+
+	for events := range input {
+		// the registrar must not move past events we could not store
+		for {
+			err := q.append(events)
+			if err == nil {
+				break
+			}
+			emit("WARNING: disk queue: could not store %d events, retry in 1s: %s\n", len(events), err)
+			time.Sleep(time.Second)
+		}
+		registrar <- events
+	}
+}
+
+func encodeRecord(events []*FileEvent) ([]byte, error) {
+	// the parsing settings are not stored, only the id of their config
+	payload, err := json.Marshal(events)
+	if err != nil {
+		return nil, err
+	}
+
+	// 4 bytes length, 4 bytes crc32, payload
+	buf := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[8:], payload)
+	return buf, nil
+}
+
+func decodeRecord(r io.Reader) ([]*FileEvent, int64, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > diskQueueMaxSize {
+		return nil, 0, errCorruptRecord
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, errCorruptRecord
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, errCorruptRecord
+	}
+
+	var events []*FileEvent
+	if err := json.Unmarshal(payload, &events); err != nil {
+		return nil, 0, errCorruptRecord
+	}
+	for _, event := range events {
+		// empty fields are stored as null
+		if event.Fields == nil {
+			event.Fields = &map[string]string{}
+		}
+	}
+	return events, int64(len(header)) + int64(length), nil
+}
+
+func (q *DiskQueue) append(events []*FileEvent) error {
+	record, err := encodeRecord(events)
+	if err != nil {
+		return err
+	}
+	length := int64(len(record))
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	// block the spooler while the queue is full, but always accept a record if the queue is empty
+	if q.size > 0 && q.size+length > q.maxSize {
+		emit("disk queue: full (%d bytes), waiting for the output\n", q.size)
+		for q.size > 0 && q.size+length > q.maxSize {
+			if q.allAcked() {
+				if err := q.dropAcked(); err != nil {
+					return err
+				}
+				continue
+			}
+			q.cond.Wait()
+		}
+	}
+
+	if q.writer == nil || (q.writeOffset > 0 && q.writeOffset+length > q.segmentSize) {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := q.writer.Write(record); err != nil {
+		return err
+	}
+	if q.fsync == "always" {
+		q.writer.Sync()
+	} else {
+		q.dirty = true
+	}
+
+	q.writeOffset += length
+	q.segmentSizes[q.writeSeg] += length
+	q.size += length
+	q.cond.Broadcast()
+	return nil
+}
+
+// allAcked returns true if every record on disk is acked, q.mutex must be held
+func (q *DiskQueue) allAcked() bool {
+	if len(q.published) > 0 || len(q.segments) == 0 {
+		return false
+	}
+	last := q.segments[len(q.segments)-1]
+	return q.cursor.Segment == last && q.cursor.Offset >= q.segmentSizes[last]
+}
+
+// dropAcked removes all the segments, when all of them are acked. the
+// segment being written is only removed after a rotation otherwise, which
+// may never come while the queue is full. q.mutex must be held
+func (q *DiskQueue) dropAcked() error {
+	if q.writer != nil {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+	q.removeSegments(q.writeSeg)
+	q.cursor = queuePosition{Segment: q.writeSeg}
+	return nil
+}
+
+// rotate starts a new segment file, q.mutex must be held
+func (q *DiskQueue) rotate() error {
+	if q.writer != nil {
+		q.writer.Sync()
+		q.writer.Close()
+		q.writeSeg++
+	}
+
+	writer, err := os.OpenFile(q.segmentPath(q.writeSeg), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		q.writer = nil
+		return err
+	}
+	q.writer = writer
+	q.writeOffset = 0
+	q.segments = append(q.segments, q.writeSeg)
+	q.segmentSizes[q.writeSeg] = 0
+	q.dirty = false
+	return nil
+}
+
+func (q *DiskQueue) syncLoop() {
+	for range time.Tick(q.syncInterval) {
+		q.mutex.Lock()
+		if q.dirty && q.writer != nil {
+			q.writer.Sync()
+			q.dirty = false
+		}
+		q.mutex.Unlock()
+	}
+}
+
+// next blocks until the record at pos is written, and returns it together
+// with the position after it. if the record could not be read, the rest of
+// its segment is skipped, the error is returned with the position of the
+// next segment.
+func (q *DiskQueue) next(pos queuePosition) ([]*FileEvent, queuePosition, error) {
+	for {
+		q.mutex.Lock()
+		for pos.Segment == q.writeSeg && pos.Offset >= q.writeOffset {
+			q.cond.Wait()
+		}
+		writing := pos.Segment == q.writeSeg
+		_, exists := q.segmentSizes[pos.Segment]
+		nextSeg := q.writeSeg
+		for _, id := range q.segments {
+			if id > pos.Segment {
+				nextSeg = id
+				break
+			}
+		}
+		q.mutex.Unlock()
+
+		// removed by dropAcked, there is nothing left to read in it
+		if !exists && !writing {
+			q.closeReader()
+			pos = queuePosition{Segment: nextSeg}
+			continue
+		}
+
+		events, length, err := q.read(pos)
+		if err == nil {
+			return events, queuePosition{Segment: pos.Segment, Offset: pos.Offset + length}, nil
+		}
+		q.closeReader()
+		if err == io.EOF && !writing {
+			pos = queuePosition{Segment: nextSeg}
+			continue
+		}
+
+		if writing {
+			// the records after it are written to a new segment
+			q.mutex.Lock()
+			if q.writeSeg == pos.Segment && q.writer != nil {
+				if rerr := q.rotate(); rerr != nil {
+					emit("disk queue: could not start a new segment: %s\n", rerr)
+				}
+			}
+			nextSeg = q.writeSeg
+			q.mutex.Unlock()
+		}
+		return nil, queuePosition{Segment: nextSeg}, fmt.Errorf("segment %d at %d: %s", pos.Segment, pos.Offset, err)
+	}
+}
+
+// read decodes the record at pos, and returns its length
+func (q *DiskQueue) read(pos queuePosition) ([]*FileEvent, int64, error) {
+	if q.reader == nil || q.readSeg != pos.Segment {
+		q.closeReader()
+		reader, err := os.Open(q.segmentPath(pos.Segment))
+		if err != nil {
+			return nil, 0, err
+		}
+		q.reader = reader
+		q.readSeg = pos.Segment
+	}
+
+	if _, err := q.reader.Seek(pos.Offset, os.SEEK_SET); err != nil {
+		return nil, 0, err
+	}
+	events, length, err := decodeRecord(q.reader)
+	if err != nil {
+		return nil, 0, err
+	}
+	q.setConfigs(events)
+	return events, length, nil
+}
+
+// setConfigs re-attaches the configs the events were read with. without it,
+// as when the input was removed from the config since, the text is published
+// as it is, like the events of the receiver.
+func (q *DiskQueue) setConfigs(events []*FileEvent) {
+	missing := make(map[string]bool)
+	for _, event := range events {
+		if event.Config == "" {
+			continue
+		}
+		fc, ok := q.configs[event.Config]
+		if !ok {
+			missing[event.Config] = true
+			continue
+		}
+		event.setConfig(fc)
+	}
+	for id := range missing {
+		emit("WARNING: disk queue: no config %s, its replayed events are not parsed\n", id)
+	}
+}
+
+func (q *DiskQueue) closeReader() {
+	if q.reader != nil {
+		q.reader.Close()
+		q.reader = nil
+	}
+}
+
+func (q *DiskQueue) publishLoop(p Publisher) {
+	q.mutex.Lock()
+	pos := q.cursor
+	q.mutex.Unlock()
+
+	for {
+		events, end, err := q.next(pos)
+		if err != nil {
+			emit("disk queue: skipping the rest of a corrupt segment: %s\n", err)
+			pos = end
+			continue
+		}
+
+		q.mutex.Lock()
+		q.published = append(q.published, end)
+		q.mutex.Unlock()
+
+		p.Publish(events)
+		pos = end
+	}
+}
+
+// ackLoop moves the cursor after acked batches and removes the segments
+// which are fully acked
+func (q *DiskQueue) ackLoop(p Publisher) {
+	for range p.Acked() {
+		q.mutex.Lock()
+		q.cursor = q.published[0]
+		q.published = q.published[1:]
+		q.removeSegments(q.cursor.Segment)
+		cursor := q.cursor
+		q.cond.Broadcast()
+		q.mutex.Unlock()
+
+		if err := q.writeCursor(cursor); err != nil {
+			emit("WARNING: disk queue: could not write cursor: %s\n", err)
+		}
+	}
+}
+
+func (q *DiskQueue) writeCursor(cursor queuePosition) error {
+	path := filepath.Join(q.conf.Path, diskQueueCursorFile)
+	tempfile := path + ".new"
+
+	buf, _ := json.Marshal(cursor)
+	file, err := os.OpenFile(tempfile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(buf)
+	if err == nil && q.fsync != "never" {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempfile, path)
+}
+
+// Close syncs and closes the segment being written
+func (q *DiskQueue) Close() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.writer == nil {
+		return nil
+	}
+	q.writer.Sync()
+	err := q.writer.Close()
+	q.writer = nil
+	return err
+}
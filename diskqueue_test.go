@@ -0,0 +1,203 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingPublisher acks every batch and records its texts
+type recordingPublisher struct {
+	acked chan []*FileEvent
+	texts chan string
+}
+
+func (p *recordingPublisher) Publish(events []*FileEvent) {
+	for _, event := range events {
+		p.texts <- *event.Text
+	}
+	p.acked <- events
+}
+func (p *recordingPublisher) Acked() <-chan []*FileEvent { return p.acked }
+func (p *recordingPublisher) Close() error               { return nil }
+
+func makeEvents(texts ...string) []*FileEvent {
+	source := "/var/log/test.log"
+	fields := map[string]string{"type": "test"}
+	var events []*FileEvent
+	for _, text := range texts {
+		text := text
+		events = append(events, &FileEvent{
+			Source:          &source,
+			Text:            &text,
+			Fields:          &fields,
+			DelimiterRegexp: regexp.MustCompile(`\s+`),
+			Config:          "files/0",
+		})
+	}
+	return events
+}
+
+func TestDiskQueueReplay(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	// small segments, so the batches are spread over several files
+	conf := &DiskQueueConfig{Path: tmpdir, SegmentSize: 200}
+	q, err := NewDiskQueue(conf, nil)
+	chkerr(t, err)
+	for _, text := range []string{"one", "two", "three", "four"} {
+		chkerr(t, q.append(makeEvents(text)))
+	}
+	q.Close()
+
+	// a new queue on the same directory replays what was not acked
+	q, err = NewDiskQueue(conf, nil)
+	chkerr(t, err)
+	p := &recordingPublisher{acked: make(chan []*FileEvent, 4), texts: make(chan string, 4)}
+	input := make(chan []*FileEvent)
+	go q.Run(input, make(chan []*FileEvent, 4), p)
+
+	for _, expected := range []string{"one", "two", "three", "four"} {
+		if text := <-p.texts; text != expected {
+			t.Fatalf("Expected %q to be replayed, got %q", expected, text)
+		}
+	}
+
+	// new batches are published after the replayed ones
+	input <- makeEvents("five")
+	if text := <-p.texts; text != "five" {
+		t.Fatalf("Expected %q, got %q", "five", text)
+	}
+}
+
+func TestDiskQueueRecord(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	record, err := encodeRecord(makeEvents("hello world"))
+	chkerr(t, err)
+
+	path := filepath.Join(tmpdir, "record")
+	chkerr(t, ioutil.WriteFile(path, record, 0644))
+
+	file, err := os.Open(path)
+	chkerr(t, err)
+	defer file.Close()
+	events, length, err := decodeRecord(file)
+	chkerr(t, err)
+
+	if length != int64(len(record)) {
+		t.Fatalf("Expected record length %d, got %d", len(record), length)
+	}
+	event := events[0]
+	if *event.Text != "hello world" || (*event.Fields)["type"] != "test" || event.Config != "files/0" {
+		t.Fatalf("Unexpected event decoded: %+v", event)
+	}
+	// the parsing settings come from the config, they are not stored
+	if event.DelimiterRegexp != nil {
+		t.Fatalf("Expected the delimiter not to be stored in the record")
+	}
+
+	// a torn record is reported as corrupt
+	chkerr(t, ioutil.WriteFile(path, record[:len(record)-1], 0644))
+	torn, err := os.Open(path)
+	chkerr(t, err)
+	defer torn.Close()
+	if _, _, err := decodeRecord(torn); err != errCorruptRecord {
+		t.Fatalf("Expected errCorruptRecord for a torn record, got %v", err)
+	}
+}
+
+func TestDiskQueueFullAcked(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	q, err := NewDiskQueue(&DiskQueueConfig{Path: tmpdir, MaxSize: 1000}, nil)
+	chkerr(t, err)
+	p := &recordingPublisher{acked: make(chan []*FileEvent, 4), texts: make(chan string, 4)}
+	input := make(chan []*FileEvent)
+	go q.Run(input, make(chan []*FileEvent, 4), p)
+
+	// each batch is larger than what is left once the other one is in the
+	// queue, the acked segment being written has to be removed
+	for _, text := range []string{strings.Repeat("a", 600), strings.Repeat("b", 600)} {
+		input <- makeEvents(text)
+		select {
+		case published := <-p.texts:
+			if published != text {
+				t.Fatalf("Unexpected event published: %q", published)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected the batch to be stored and published")
+		}
+	}
+}
+
+func TestDiskQueueCorruptRecord(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	q, err := NewDiskQueue(&DiskQueueConfig{Path: tmpdir}, nil)
+	chkerr(t, err)
+	chkerr(t, q.append(makeEvents("one")))
+	start := q.cursor
+
+	// damage the payload of the record in the segment being written
+	segment, err := os.OpenFile(q.segmentPath(start.Segment), os.O_WRONLY, 0644)
+	chkerr(t, err)
+	_, err = segment.WriteAt([]byte("X"), 10)
+	chkerr(t, err)
+	segment.Close()
+
+	_, pos, err := q.next(start)
+	if err == nil || pos.Segment == start.Segment {
+		t.Fatalf("Expected the corrupt segment to be skipped, got %v at %v", err, pos)
+	}
+
+	// the next records are written to the next segment
+	chkerr(t, q.append(makeEvents("two")))
+	events, _, err := q.next(pos)
+	chkerr(t, err)
+	if *events[0].Text != "two" {
+		t.Fatalf("Expected the record after the corrupt one, got %q", *events[0].Text)
+	}
+	q.Close()
+}
+
+func TestDiskQueueReplayConfig(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	config := &Config{Files: []FileConfig{{
+		Delimiter:  `\s+`,
+		FieldNames: []string{"level", "message"},
+		Timestamp:  &TimestampConfig{Field: "time", Layouts: []string{time.RFC3339}},
+	}}}
+	chkerr(t, SplitConf(config))
+
+	conf := &DiskQueueConfig{Path: tmpdir}
+	q, err := NewDiskQueue(conf, nil)
+	chkerr(t, err)
+	source, text := "/var/log/test.log", "info hello"
+	chkerr(t, q.append([]*FileEvent{config.Files[0].newEvent(&source, &text, 0, 1)}))
+	q.Close()
+
+	// the replayed event is parsed with the live config it was read with
+	q, err = NewDiskQueue(conf, config.fileConfigs())
+	chkerr(t, err)
+	defer q.Close()
+	events, _, err := q.next(q.cursor)
+	chkerr(t, err)
+	event := events[0]
+	if event.DelimiterRegexp != config.Files[0].DelimiterRegexp || event.Timestamp != config.Files[0].Timestamp {
+		t.Fatalf("Expected the config of files/0 on the replayed event, got %+v", event)
+	}
+	if e := decodeJsonFormat(t, event); e["level"] != "info" || e["message"] != "hello" {
+		t.Fatalf("Expected the replayed event to be split, got %v", e)
+	}
+}
@@ -3,25 +3,93 @@ package main
 import (
 	"os"
 	"regexp"
+	"time"
 )
 
+// FileEvent is a line read by an input. the parsing settings come from the
+// FileConfig of the input, they are not stored in the disk queue, events
+// replayed from it get them back from the config with the id of Config.
 type FileEvent struct {
-	Source           *string `json:"source,omitempty"`
-	Offset           int64   `json:"offset,omitempty"`
-	Line             uint64  `json:"line,omitempty"`
-	Text             *string `json:"text,omitempty"`
-	Fields           *map[string]string
-	FieldNames       []string `json:fieldnames`
-	DelimiterRegexp  *regexp.Regexp
-	ExactMatch       bool
-	QuoteChar        string
-	FieldNamesLength int
-	Hostname         *string
-	NoHostname       bool
-	NoPath           bool
-	NoTimestamp      bool
-	MaxBytes         int
+	Source            *string `json:"source,omitempty"`
+	Offset            int64   `json:"offset,omitempty"`
+	Line              uint64  `json:"line,omitempty"`
+	Text              *string `json:"text,omitempty"`
+	Fields            *map[string]string
+	FieldNames        []string          `json:"-"`
+	FieldTypes        []string          `json:"-"`
+	ConversionFailure string            `json:"-"`
+	DelimiterRegexp   *regexp.Regexp    `json:"-"`
+	PatternRegexp     *regexp.Regexp    `json:"-"`
+	CaptureTypes      map[string]string `json:"-"`
+	CaptureNames      map[string]string `json:"-"`
+	PatternFailure    string            `json:"-"`
+	Codec             string            `json:"-"`
+	JSON              *JSONCodecConfig  `json:"-"`
+	KV                *KVCodecConfig    `json:"-"`
+	CSV               *CSVCodecConfig   `json:"-"`
+	Columns           []string          `json:"columns,omitempty"`
+	Cursor            string            `json:"cursor,omitempty"` // position of journald events
+	Fingerprint       string            `json:"fingerprint,omitempty"`
+	FingerprintSize   int64             `json:"fingerprint_size,omitempty"`
+	ExactMatch        bool              `json:"-"`
+	QuoteChar         string            `json:"-"`
+	FieldNamesLength  int               `json:"-"`
+	Hostname          *string
+	NoHostname        bool
+	NoPath            bool             `json:"-"`
+	NoTimestamp       bool             `json:"-"`
+	Timestamp         *TimestampConfig `json:"-"`
+	MaxBytes          int
+	Config            string `json:"config,omitempty"` // id of the FileConfig, set by SplitConf
+	// offset after the bytes of the event in the file, with the end of line,
+	// the offset the harvest resumes at
+	EndOffset int64     `json:"end_offset,omitempty"`
+	Time      time.Time `json:"time"`           // time of the line written by the input, the default @timestamp
+	Done      bool      `json:"done,omitempty"` // set on the last event of an archive read to its end
+	// not published, only saved by the registrar, archives without events are marked done with it
+	StateOnly bool `json:"state_only,omitempty"`
 
 	ileinfo  *os.FileInfo
 	fileinfo *os.FileInfo
+	// called by registrar after the event is published, events not read from files use it
+	ack func()
+}
+
+// newEvent creates an event of text read from source, with the settings of the FileConfig
+func (fc *FileConfig) newEvent(source *string, text *string, offset int64, line uint64) *FileEvent {
+	event := &FileEvent{
+		NoHostname: fc.NoHostname,
+		MaxBytes:   fc.MaxBytes,
+		Hostname:   &fc.Hostname,
+		Source:     source,
+		Offset:     offset,
+		Line:       line,
+		Text:       text,
+		Fields:     &fc.Fields,
+		Config:     fc.id,
+	}
+	event.setConfig(fc)
+	return event
+}
+
+// setConfig sets the settings of fc the text of the event is parsed with
+func (event *FileEvent) setConfig(fc *FileConfig) {
+	event.NoTimestamp = fc.NoTimestamp
+	event.Timestamp = fc.Timestamp
+	event.NoPath = fc.NoPath
+	event.FieldNames = fc.FieldNames
+	event.FieldTypes = fc.FieldTypes
+	event.ConversionFailure = fc.ConversionFailure
+	event.DelimiterRegexp = fc.DelimiterRegexp
+	event.PatternRegexp = fc.PatternRegexp
+	event.CaptureTypes = fc.CaptureTypes
+	event.CaptureNames = fc.CaptureNames
+	event.PatternFailure = fc.PatternFailure
+	event.Codec = fc.Codec
+	event.JSON = fc.JSON
+	event.KV = fc.KV
+	event.CSV = fc.CSV
+	event.ExactMatch = fc.ExactMatch
+	event.QuoteChar = fc.QuoteChar
+	event.FieldNamesLength = fc.FieldNamesLength
 }
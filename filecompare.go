@@ -18,7 +18,7 @@ func is_fileinfo_same(a os.FileInfo, b os.FileInfo) bool {
   return (af.Dev == bf.Dev && af.Ino == bf.Ino)
 }
 
-func is_file_renamed(file string, info os.FileInfo, fileinfo map[string]ProspectorInfo, missingfiles map[string]os.FileInfo) string {
+func is_file_renamed(file string, info os.FileInfo, fileinfo map[string]ProspectorInfo, missingfiles map[string]ProspectorInfo) string {
   // NOTE(driskell): What about using golang's func os.SameFile(fi1, fi2 FileInfo) bool instead?
   stat := info.Sys().(*syscall.Stat_t)
 
@@ -26,22 +26,34 @@ func is_file_renamed(file string, info os.FileInfo, fileinfo map[string]Prospect
     if kf == file {
       continue
     }
-    ks := ki.fileinfo.Sys().(*syscall.Stat_t)
-    if stat.Dev == ks.Dev && stat.Ino == ks.Ino {
+    if is_prospectorinfo_same(file, stat, kf, ki) {
       return kf
     }
   }
 
   // Now check the missingfiles
   for kf, ki := range missingfiles {
-    ks := ki.Sys().(*syscall.Stat_t)
-    if stat.Dev == ks.Dev && stat.Ino == ks.Ino {
+    if is_prospectorinfo_same(file, stat, kf, ki) {
       return kf
     }
   }
   return ""
 }
 
+// is_prospectorinfo_same returns true if file is the one known as kf, the inode
+// of a deleted file could have been reused by a new one with another start
+func is_prospectorinfo_same(file string, stat *syscall.Stat_t, kf string, ki ProspectorInfo) bool {
+  ks := ki.fileinfo.Sys().(*syscall.Stat_t)
+  if stat.Dev != ks.Dev || stat.Ino != ks.Ino {
+    return false
+  }
+  if !has_fingerprint(file, ki.fingerprint, ki.fingerprintSize) {
+    emit("Not a rename, the inode of %s was reused by: %s\n", kf, file)
+    return false
+  }
+  return true
+}
+
 func is_file_renamed_resumelist(file string, info os.FileInfo, initial map[string]*FileState) string {
   // NOTE(driskell): What about using golang's func os.SameFile(fi1, fi2 FileInfo) bool instead?
   stat := info.Sys().(*syscall.Stat_t)
@@ -1,9 +1,14 @@
 package main
 
 type FileState struct {
-  Source *string `json:"source,omitempty"`
-  Offset int64 `json:"offset,omitempty"`
-  Inode uint64 `json:"inode,omitempty"`
-  Device int32 `json:"device,omitempty"`
+  Source          *string `json:"source,omitempty"`
+  Offset          int64   `json:"offset,omitempty"`
+  Inode           uint64  `json:"inode,omitempty"`
+  Device          int32   `json:"device,omitempty"`
+  Cursor          string  `json:"cursor,omitempty"`           // journald inputs have a cursor instead of a file position
+  Done            bool    `json:"done,omitempty"`             // archives read to their end, the offset is in the decompressed content
+  Fingerprint     string  `json:"fingerprint,omitempty"`      // hash of the first FingerprintSize bytes, the file is resumed only if they did not change
+  FingerprintSize int64   `json:"fingerprint_size,omitempty"`
+  LastSeen        int64   `json:"last_seen,omitempty"`        // unix time the registrar last updated the state
 }
 
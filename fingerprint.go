@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+)
+
+// the first fingerprintSize bytes of a file identify it with its inode, inodes
+// of deleted files are reused by new ones
+const fingerprintSize = 1024
+
+// fingerprint returns the hash of the first size bytes of file
+func fingerprint(file io.ReaderAt, size int64) (string, error) {
+	data := make([]byte, size)
+	if _, err := file.ReadAt(data, 0); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%x", sum), nil
+}
+
+// is_fingerprint_same returns true if the file at path starts with the bytes
+// fingerprinted in state. states saved without fingerprint match any file.
+func is_fingerprint_same(path string, state *FileState) bool {
+	return has_fingerprint(path, state.Fingerprint, state.FingerprintSize)
+}
+
+// has_fingerprint returns true if the hash of the first size bytes of the
+// file at path is sum, or if sum is empty
+func has_fingerprint(path string, sum string, size int64) bool {
+	if sum == "" {
+		return true
+	}
+	file, err := openfile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	filesum, err := fingerprint(file, size)
+	return err == nil && filesum == sum
+}
+
+// update_fingerprint hashes the start of file again while it is smaller than
+// fingerprintSize, or if it was truncated, for the rename detection of scan
+func (info *ProspectorInfo) update_fingerprint(file string, fileinfo os.FileInfo) {
+	size := fileinfo.Size()
+	if size > fingerprintSize {
+		size = fingerprintSize
+	}
+	if info.fingerprint != "" && size == info.fingerprintSize {
+		return
+	}
+	f, err := openfile(file, os.O_RDONLY, 0)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if sum, err := fingerprint(f, size); err == nil {
+		info.fingerprint, info.fingerprintSize = sum, size
+	}
+}
+
+// updateFingerprint hashes the start of the file again, up to what was read,
+// until fingerprintSize bytes are. archives are read whole, their compressed
+// bytes are hashed.
+func (h *Harvester) updateFingerprint() {
+	if h.file == os.Stdin || h.fingerprintSize >= fingerprintSize {
+		return
+	}
+	end := h.Offset
+	if h.archive != nil {
+		info, err := h.file.Stat()
+		if err != nil {
+			return
+		}
+		end = info.Size()
+	}
+	if end > fingerprintSize {
+		end = fingerprintSize
+	}
+	if end <= h.fingerprintSize {
+		return
+	}
+	if sum, err := fingerprint(h.file, end); err == nil {
+		h.fingerprint, h.fingerprintSize = sum, end
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHarvesterFingerprint(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	path := filepath.Join(tmpdir, "app.log")
+	long := strings.Repeat("x", fingerprintSize)
+	content := "first\n" + long + "\nlast\n"
+	chkerr(t, ioutil.WriteFile(path, []byte(content), 0644))
+
+	output := make(chan *FileEvent, 10)
+	h := &Harvester{Path: path, FileConfig: FileConfig{MaxBytes: 4096}, FinishChan: make(chan int64, 1)}
+	go h.Harvest(output)
+
+	file, err := os.Open(path)
+	chkerr(t, err)
+	defer file.Close()
+
+	// the fingerprint covers what was read, up to fingerprintSize
+	for _, size := range []int64{6, fingerprintSize, fingerprintSize} {
+		event := <-output
+		expected, err := fingerprint(file, size)
+		chkerr(t, err)
+		if event.FingerprintSize != size || event.Fingerprint != expected {
+			t.Fatalf("Expected the fingerprint of %d bytes, got %d bytes %s", size, event.FingerprintSize, event.Fingerprint)
+		}
+	}
+}
+
+func TestResumeChecksFingerprint(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	path := filepath.Join(tmpdir, "app.log")
+	chkerr(t, ioutil.WriteFile(path, []byte("first\nsecond\n"), 0644))
+
+	output := make(chan *FileEvent, 10)
+	h := &Harvester{Path: path, FileConfig: FileConfig{MaxBytes: 4096}, FinishChan: make(chan int64, 1)}
+	go h.Harvest(output)
+	events := []*FileEvent{<-output, <-output}
+
+	registrar := make(map[string]*FileState)
+	input := make(chan []*FileEvent, 1)
+	input <- events
+	close(input)
+	Registrar(registrar, filepath.Join(tmpdir, ".logstash-forwarder"), nil, input)
+
+	resume := &ProspectorResume{files: registrar, persist: make(chan *FileState, 10)}
+	p := &Prospector{}
+
+	// appended, the same file
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	chkerr(t, err)
+	_, err = file.WriteString("third\n")
+	chkerr(t, err)
+	file.Close()
+	fileinfo, err := os.Stat(path)
+	chkerr(t, err)
+	if offset, resuming := p.calculate_resume(path, fileinfo, resume); !resuming || offset != 13 {
+		t.Fatalf("Expected to resume the appended file at 13, got %d, %t", offset, resuming)
+	}
+
+	// rewritten in place, as a new file with the inode of the old one is
+	chkerr(t, ioutil.WriteFile(path, []byte("another file\n"), 0644))
+	fileinfo, err = os.Stat(path)
+	chkerr(t, err)
+	if !is_file_same(path, fileinfo, registrar[path]) {
+		t.Skipf("The inode of %s changed", path)
+	}
+	if offset, resuming := p.calculate_resume(path, fileinfo, resume); resuming {
+		t.Fatalf("Expected the new file not to be resumed, got offset %d", offset)
+	}
+
+	// states saved before fingerprints are resumed on the inode alone
+	registrar[path].Fingerprint = ""
+	if _, resuming := p.calculate_resume(path, fileinfo, resume); !resuming {
+		t.Fatalf("Expected a state without fingerprint to be resumed")
+	}
+}
+
+func TestScanInodeReused(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	// deleted.log was deleted, and its inode reused by new.log before the scan
+	path := filepath.Join(tmpdir, "new.log")
+	chkerr(t, ioutil.WriteFile(path, []byte("new file\n"), 0644))
+	fileinfo, err := os.Stat(path)
+	chkerr(t, err)
+	deleted := ProspectorInfo{fileinfo: fileinfo, harvester: make(chan int64, 1)}
+	deleted.fingerprint, err = fingerprint(strings.NewReader("old file\n"), 9)
+	chkerr(t, err)
+	deleted.fingerprintSize = 9
+
+	if previous := is_file_renamed(path, fileinfo, map[string]ProspectorInfo{"deleted.log": deleted}, nil); previous != "" {
+		t.Fatalf("Expected the new file not to be a rename of %s", previous)
+	}
+	renamed := ProspectorInfo{fileinfo: fileinfo}
+	renamed.update_fingerprint(path, fileinfo)
+	if previous := is_file_renamed(path, fileinfo, nil, map[string]ProspectorInfo{"renamed.log": renamed}); previous != "renamed.log" && runtime.GOOS != "windows" {
+		t.Fatalf("Expected the file to be a rename of renamed.log, got %q", previous)
+	}
+
+	// the new file is harvested from its start
+	output := make(chan *FileEvent, 10)
+	p := &Prospector{FileConfig: FileConfig{MaxBytes: 4096}, lastscan: time.Now().Add(-time.Minute)}
+	p.prospectorinfo = map[string]ProspectorInfo{filepath.Join(tmpdir, "deleted.log"): deleted}
+	p.scan(filepath.Join(tmpdir, "*.log"), output, nil)
+	select {
+	case event := <-output:
+		if *event.Text != "new file" || event.Offset != 0 {
+			t.Fatalf("Unexpected event %q at %d", *event.Text, event.Offset)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected a harvester on the new file")
+	}
+	if p.prospectorinfo[path].harvester == deleted.harvester {
+		t.Fatalf("Expected the new file not to take the harvester of the deleted one")
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// globFiles returns the files matching pattern. ** matches any number of
+// directories, "/var/log/app/**/*.log" matches the logs in /var/log/app and
+// in all the directories below it. patterns without ** are filepath.Glob ones.
+func globFiles(pattern string) ([]string, error) {
+	if !strings.Contains(pattern, "**") {
+		return filepath.Glob(pattern)
+	}
+	// the paths walked are clean ones
+	pattern = filepath.Clean(pattern)
+	re, err := globRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	err = filepath.Walk(staticDir(pattern), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// unreadable directories are skipped, as filepath.Glob does
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && re.MatchString(filepath.ToSlash(path)) {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return matches, err
+}
+
+// globSubdirs returns the directories in which files matching a ** pattern
+// could be created
+func globSubdirs(pattern string) []string {
+	var dirs []string
+	filepath.Walk(staticDir(pattern), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	return dirs
+}
+
+// staticDir is the deepest directory of pattern without wildcards
+func staticDir(pattern string) string {
+	dir := filepath.Dir(pattern)
+	for strings.ContainsAny(dir, "*?[") {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// globRegexp translates a glob to a regexp matching the whole slash separated
+// path. * and ? do not match the separator, ** matches any number of directories.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid glob %q: %s", pattern, err)
+	}
+	pattern = filepath.ToSlash(pattern)
+
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**/") {
+				re.WriteString("(?:.*/)?")
+				i += 2
+			} else if strings.HasPrefix(pattern[i:], "**") {
+				re.WriteString(".*")
+				i++
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '[':
+			// the class is the same in regexps, but for \] which could be in it
+			end := i + 1
+			for end < len(pattern) && pattern[end] != ']' {
+				if pattern[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(pattern) {
+				return nil, fmt.Errorf("invalid glob %q: %s", pattern, filepath.ErrBadPattern)
+			}
+			re.WriteString(pattern[i : end+1])
+			i = end
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
+
+// compileExcludeFiles compiles the ExcludeFiles of fileconfig. "/<regexp>/"
+// entries are regexps matched against the path, the others are globs matched
+// against the path, or against the name of the file if they have no directory.
+func compileExcludeFiles(fileconfig *FileConfig) error {
+	fileconfig.excludeFiles = nil
+	for _, exclude := range fileconfig.ExcludeFiles {
+		var re *regexp.Regexp
+		var err error
+		if len(exclude) > 2 && strings.HasPrefix(exclude, "/") && strings.HasSuffix(exclude, "/") {
+			re, err = regexp.Compile(exclude[1 : len(exclude)-1])
+		} else {
+			if !strings.ContainsAny(filepath.ToSlash(exclude), "/") {
+				exclude = "**/" + exclude
+			}
+			re, err = globRegexp(exclude)
+		}
+		if err != nil {
+			return fmt.Errorf("exclude_files: %s", err)
+		}
+		fileconfig.excludeFiles = append(fileconfig.excludeFiles, re)
+	}
+	return nil
+}
+
+// compileLineFilters compiles the IncludeLines and ExcludeLines of fileconfig
+func compileLineFilters(fileconfig *FileConfig) (err error) {
+	if fileconfig.includeLines, err = compileRegexps(fileconfig.IncludeLines); err != nil {
+		return fmt.Errorf("include_lines: %s", err)
+	}
+	if fileconfig.excludeLines, err = compileRegexps(fileconfig.ExcludeLines); err != nil {
+		return fmt.Errorf("exclude_lines: %s", err)
+	}
+	return nil
+}
+
+func compileRegexps(patterns []string) ([]*regexp.Regexp, error) {
+	var regexps []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		regexps = append(regexps, re)
+	}
+	return regexps, nil
+}
+
+func matchAny(regexps []*regexp.Regexp, s string) bool {
+	for _, re := range regexps {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludeFile returns true if path is excluded by ExcludeFiles
+func (f *FileConfig) excludeFile(path string) bool {
+	return matchAny(f.excludeFiles, filepath.ToSlash(path))
+}
+
+// keepLine returns true if text is not dropped by IncludeLines or
+// ExcludeLines. the lines must match one of IncludeLines, if set, and none
+// of ExcludeLines.
+func (f *FileConfig) keepLine(text string) bool {
+	if len(f.includeLines) > 0 && !matchAny(f.includeLines, text) {
+		return false
+	}
+	return !matchAny(f.excludeLines, text)
+}
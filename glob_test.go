@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGlobFilesRecursive(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	files := []string{"app.log", "a/app.log", "a/b/app.log", "a/b/app.log.gz", "a/b/debug.log", "other/app.txt"}
+	for _, file := range files {
+		path := filepath.Join(tmpdir, file)
+		chkerr(t, os.MkdirAll(filepath.Dir(path), 0755))
+		chkerr(t, ioutil.WriteFile(path, []byte("line\n"), 0644))
+	}
+
+	matches, err := globFiles(filepath.Join(tmpdir, "**", "*.log"))
+	chkerr(t, err)
+	expected := []string{
+		filepath.Join(tmpdir, "a", "app.log"),
+		filepath.Join(tmpdir, "a", "b", "app.log"),
+		filepath.Join(tmpdir, "a", "b", "debug.log"),
+		filepath.Join(tmpdir, "app.log"),
+	}
+	if !reflect.DeepEqual(matches, expected) {
+		t.Fatalf("Expected %v, got %v", expected, matches)
+	}
+
+	matches, err = globFiles(filepath.Join(tmpdir, "a", "**"))
+	chkerr(t, err)
+	if len(matches) != 4 {
+		t.Fatalf("Expected the 4 files below a, got %v", matches)
+	}
+
+	matches, err = globFiles(filepath.Join(tmpdir, "missing", "**", "*.log"))
+	if err != nil || len(matches) != 0 {
+		t.Fatalf("Expected no match and no error, got %v, %v", matches, err)
+	}
+}
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		glob  string
+		path  string
+		match bool
+	}{
+		{"/var/log/**/*.log", "/var/log/app.log", true},
+		{"/var/log/**/*.log", "/var/log/a/b/app.log", true},
+		{"/var/log/**/*.log", "/var/log/a/app.log.1", false},
+		{"/var/log/*.log", "/var/log/a/app.log", false},
+		{"/var/log/app-?.log", "/var/log/app-1.log", true},
+		{"/var/log/app-[^0-9].log", "/var/log/app-1.log", false},
+		{`/var/log/app-[\]x].log`, "/var/log/app-].log", true},
+		{"/var/log/app.log", "/var/log/appxlog", false},
+	}
+	for _, test := range tests {
+		re, err := globRegexp(test.glob)
+		chkerr(t, err)
+		if match := re.MatchString(test.path); match != test.match {
+			t.Errorf("Expected %s matching %s: %t, got %t (%s)", test.glob, test.path, test.match, match, re)
+		}
+	}
+
+	if _, err := globRegexp("/var/log/[a.log"); err == nil {
+		t.Fatalf("Expected an error for an unclosed class")
+	}
+}
+
+func TestExcludeFiles(t *testing.T) {
+	fileconfig := &FileConfig{ExcludeFiles: []string{"*.gz", "*debug*", "/var/log/old/*", `/\.[0-9]+$/`}}
+	chkerr(t, compileExcludeFiles(fileconfig))
+
+	tests := map[string]bool{
+		"/var/log/app/app.log":       false,
+		"/var/log/app/app.log.gz":    true,
+		"/var/log/app/a/debug.log":   true,
+		"/var/log/old/app.log":       true,
+		"/var/log/old/a/app.log":     false,
+		"/var/log/app/app.log.1":     true,
+		"/var/log/app/app.log.1.txt": false,
+	}
+	for path, expected := range tests {
+		if excluded := fileconfig.excludeFile(path); excluded != expected {
+			t.Errorf("Expected %s excluded: %t, got %t", path, expected, excluded)
+		}
+	}
+
+	if err := compileExcludeFiles(&FileConfig{ExcludeFiles: []string{"/(/"}}); err == nil {
+		t.Fatalf("Expected an error for an invalid regexp")
+	}
+}
+
+func TestKeepLine(t *testing.T) {
+	fileconfig := &FileConfig{IncludeLines: []string{"^ERR", "^WARN"}, ExcludeLines: []string{"healthcheck"}}
+	chkerr(t, compileLineFilters(fileconfig))
+
+	tests := map[string]bool{
+		"ERR disk full":          true,
+		"WARN healthcheck slow":  false,
+		"INFO started":           false,
+		"WARN retrying in 5s...": true,
+	}
+	for text, expected := range tests {
+		if keep := fileconfig.keepLine(text); keep != expected {
+			t.Errorf("Expected %q kept: %t, got %t", text, expected, keep)
+		}
+	}
+
+	if !(&FileConfig{}).keepLine("anything") {
+		t.Fatalf("Expected all the lines kept without filters")
+	}
+}
+
+func TestHarvesterDropsExcludedLines(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	path := filepath.Join(tmpdir, "app.log")
+	chkerr(t, ioutil.WriteFile(path, []byte("GET /healthcheck\nGET /index.html\nGET /healthcheck\nGET /cart\n"), 0644))
+
+	fileconfig := FileConfig{ExcludeLines: []string{"healthcheck"}, MaxBytes: 1024}
+	chkerr(t, compileLineFilters(&fileconfig))
+	output := make(chan *FileEvent, 10)
+	h := &Harvester{Path: path, FileConfig: fileconfig, FinishChan: make(chan int64, 1)}
+	go h.Harvest(output)
+
+	// the offsets of the events still count the dropped lines
+	expected := []struct {
+		text   string
+		offset int64
+	}{
+		{"GET /index.html", 17},
+		{"GET /cart", 50},
+	}
+	for _, e := range expected {
+		event := <-output
+		if *event.Text != e.text || event.Offset != e.offset {
+			t.Fatalf("Expected %q at offset %d, got %q at %d", e.text, e.offset, *event.Text, event.Offset)
+		}
+	}
+}
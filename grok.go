@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// grokPatterns are the built-in grok patterns. they are taken from the logstash
+// grok-patterns, with the look-arounds go regexp does not support removed.
+var grokPatterns = map[string]string{
+	"USERNAME":     `[a-zA-Z0-9._-]+`,
+	"USER":         `%{USERNAME}`,
+	"INT":          `(?:[+-]?(?:[0-9]+))`,
+	"BASE10NUM":    `(?:[+-]?(?:[0-9]+(?:\.[0-9]+)?|\.[0-9]+))`,
+	"NUMBER":       `(?:%{BASE10NUM})`,
+	"BASE16NUM":    `(?:[+-]?(?:0x)?(?:[0-9A-Fa-f]+))`,
+	"POSINT":       `\b(?:[1-9][0-9]*)\b`,
+	"NONNEGINT":    `\b(?:[0-9]+)\b`,
+	"WORD":         `\b\w+\b`,
+	"NOTSPACE":     `\S+`,
+	"SPACE":        `\s*`,
+	"DATA":         `.*?`,
+	"GREEDYDATA":   `.*`,
+	"QUOTEDSTRING": `(?:"(?:\\.|[^\\"])*"|'(?:\\.|[^\\'])*'|` + "`(?:\\\\.|[^\\\\`])*`)",
+	"QS":           `%{QUOTEDSTRING}`,
+	"UUID":         `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+
+	"MAC":        `(?:%{CISCOMAC}|%{WINDOWSMAC}|%{COMMONMAC})`,
+	"CISCOMAC":   `(?:(?:[A-Fa-f0-9]{4}\.){2}[A-Fa-f0-9]{4})`,
+	"WINDOWSMAC": `(?:(?:[A-Fa-f0-9]{2}-){5}[A-Fa-f0-9]{2})`,
+	"COMMONMAC":  `(?:(?:[A-Fa-f0-9]{2}:){5}[A-Fa-f0-9]{2})`,
+	// loose, it accepts some invalid addresses
+	"IPV6":     `(?:(?:[0-9A-Fa-f]{1,4}:){1,7}(?::|[0-9A-Fa-f]{1,4}|(?::[0-9A-Fa-f]{1,4}){1,6})|::(?:[0-9A-Fa-f]{1,4}(?::[0-9A-Fa-f]{1,4}){0,6})?)(?:%\w+)?`,
+	"IPV4":     `(?:(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.){3}(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)`,
+	"IP":       `(?:%{IPV4}|%{IPV6})`,
+	"HOSTNAME": `\b(?:[0-9A-Za-z][0-9A-Za-z-]{0,62})(?:\.(?:[0-9A-Za-z][0-9A-Za-z-]{0,62}))*\.?`,
+	"HOST":     `%{HOSTNAME}`,
+	"IPORHOST": `(?:%{IP}|%{HOSTNAME})`,
+	"HOSTPORT": `%{IPORHOST}:%{POSINT}`,
+
+	"PATH":         `(?:%{UNIXPATH}|%{WINPATH})`,
+	"UNIXPATH":     `(?:/[\w_%!$@:.,+~-]*)+`,
+	"WINPATH":      `(?:[A-Za-z]+:|\\)(?:\\[^\\?*]*)+`,
+	"URIPROTO":     `[A-Za-z][A-Za-z0-9+\-.]*`,
+	"URIHOST":      `%{IPORHOST}(?::%{POSINT})?`,
+	"URIPATH":      `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+`,
+	"URIPARAM":     `\?[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\-\[\]<>]*`,
+	"URIPATHPARAM": `%{URIPATH}(?:%{URIPARAM})?`,
+	"URI":          `%{URIPROTO}://(?:%{USER}(?::[^@]*)?@)?(?:%{URIHOST})?(?:%{URIPATHPARAM})?`,
+
+	"MONTH":             `\b(?:[Jj]an(?:uary)?|[Ff]eb(?:ruary)?|[Mm]ar(?:ch)?|[Aa]pr(?:il)?|[Mm]ay|[Jj]un(?:e)?|[Jj]ul(?:y)?|[Aa]ug(?:ust)?|[Ss]ep(?:tember)?|[Oo]ct(?:ober)?|[Nn]ov(?:ember)?|[Dd]ec(?:ember)?)\b`,
+	"MONTHNUM":          `(?:0?[1-9]|1[0-2])`,
+	"MONTHDAY":          `(?:(?:0[1-9])|(?:[12][0-9])|(?:3[01])|[1-9])`,
+	"DAY":               `(?:Mon(?:day)?|Tue(?:sday)?|Wed(?:nesday)?|Thu(?:rsday)?|Fri(?:day)?|Sat(?:urday)?|Sun(?:day)?)`,
+	"YEAR":              `(?:\d\d){1,2}`,
+	"HOUR":              `(?:2[0123]|[01]?[0-9])`,
+	"MINUTE":            `(?:[0-5][0-9])`,
+	"SECOND":            `(?:(?:[0-5]?[0-9]|60)(?:[:.,][0-9]+)?)`,
+	"TIME":              `%{HOUR}:%{MINUTE}(?::%{SECOND})?`,
+	"DATE_US":           `%{MONTHNUM}[/-]%{MONTHDAY}[/-]%{YEAR}`,
+	"DATE_EU":           `%{MONTHDAY}[./-]%{MONTHNUM}[./-]%{YEAR}`,
+	"ISO8601_TIMEZONE":  `(?:Z|[+-]%{HOUR}(?::?%{MINUTE}))`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?%{ISO8601_TIMEZONE}?`,
+	"DATE":              `%{DATE_US}|%{DATE_EU}`,
+	"DATESTAMP":         `%{DATE}[- ]%{TIME}`,
+	"TZ":                `(?:[APMCE][SD]T|UTC)`,
+	"HTTPDATE":          `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} %{INT}`,
+	"SYSLOGTIMESTAMP":   `%{MONTH} +%{MONTHDAY} %{TIME}`,
+
+	"PROG":       `[\x21-\x5a\x5c\x5e-\x7e]+`,
+	"SYSLOGPROG": `%{PROG:program}(?:\[%{POSINT:pid}\])?`,
+	"SYSLOGHOST": `%{IPORHOST}`,
+	"SYSLOGBASE": `%{SYSLOGTIMESTAMP:timestamp} %{SYSLOGHOST:logsource} %{SYSLOGPROG}:`,
+	"LOGLEVEL":   `(?:[Aa]lert|ALERT|[Tt]race|TRACE|[Dd]ebug|DEBUG|[Nn]otice|NOTICE|[Ii]nfo|INFO|[Ww]arn?(?:ing)?|WARN?(?:ING)?|[Ee]rr?(?:or)?|ERR?(?:OR)?|[Cc]rit?(?:ical)?|CRIT?(?:ICAL)?|[Ff]atal|FATAL|[Ss]evere|SEVERE|EMERG(?:ENCY)?|[Ee]merg(?:ency)?)`,
+
+	"COMMONAPACHELOG":   `%{IPORHOST:clientip} %{USER:ident} %{USER:auth} \[%{HTTPDATE:timestamp}\] "(?:%{WORD:verb} %{NOTSPACE:request}(?: HTTP/%{NUMBER:httpversion})?|%{DATA:rawrequest})" %{NUMBER:response} (?:%{NUMBER:bytes}|-)`,
+	"COMBINEDAPACHELOG": `%{COMMONAPACHELOG} %{QS:referrer} %{QS:agent}`,
+}
+
+// %{SYNTAX}, %{SYNTAX:SEMANTIC} or %{SYNTAX:SEMANTIC:TYPE}. a semantic could
+// be dotted, %{IP:client.ip}
+var grokReference = regexp.MustCompile(`^%\{(\w+)(?::(\w+(?:\.\w+)*))?(?::(\w+))?\}$`)
+
+// anything looking like a reference, to report the malformed ones
+var grokAnyReference = regexp.MustCompile(`%\{[^{}]*\}`)
+
+// go regexp group names are words, the groups of dotted semantics are named
+// grokGroupPrefix with a number
+const grokGroupPrefix = "_grok"
+
+// grok has more nesting than this only if a pattern references itself
+const grokMaxDepth = 32
+
+// grok types of %{SYNTAX:SEMANTIC:TYPE} to FieldTypes
+var grokTypes = map[string]string{
+	"int":     "integer",
+	"integer": "integer",
+	"float":   "float",
+	"boolean": "boolean",
+	"string":  "string",
+}
+
+// loadGrokPatterns reads pattern files, each line is "NAME regexp". blank
+// lines and lines starting with # are skipped.
+func loadGrokPatterns(files []string) (map[string]string, error) {
+	patterns := make(map[string]string)
+	for name, pattern := range grokPatterns {
+		patterns[name] = pattern
+	}
+
+	for _, path := range files {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		for lineno := 1; scanner.Scan(); lineno++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			parts := strings.SplitN(line, " ", 2)
+			if len(parts) != 2 {
+				file.Close()
+				return nil, fmt.Errorf("%s:%d: expected \"NAME regexp\"", path, lineno)
+			}
+			patterns[parts[0]] = strings.TrimSpace(parts[1])
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return patterns, nil
+}
+
+// expandGrok replaces the %{...} references in pattern with their regexps.
+// references with a semantic become named groups, the types of them are
+// added to captureTypes, and the fields of the groups not named after them
+// to captureNames.
+func expandGrok(pattern string, patterns map[string]string, captureTypes map[string]string, captureNames map[string]string) (string, error) {
+	return expandGrokDepth(pattern, patterns, captureTypes, captureNames, 0)
+}
+
+func expandGrokDepth(pattern string, patterns map[string]string, captureTypes map[string]string, captureNames map[string]string, depth int) (string, error) {
+	if depth > grokMaxDepth {
+		return "", fmt.Errorf("grok: patterns nested too deep, is there a loop?")
+	}
+
+	var err error
+	expanded := grokAnyReference.ReplaceAllStringFunc(pattern, func(reference string) string {
+		if err != nil {
+			return ""
+		}
+		parts := grokReference.FindStringSubmatch(reference)
+		if parts == nil {
+			err = fmt.Errorf("grok: malformed reference %s, expected %%{SYNTAX}, %%{SYNTAX:SEMANTIC} or %%{SYNTAX:SEMANTIC:TYPE}", reference)
+			return ""
+		}
+		syntax, semantic, grokType := parts[1], parts[2], parts[3]
+
+		definition, ok := patterns[syntax]
+		if !ok {
+			err = fmt.Errorf("grok: unknown pattern %%{%s}", syntax)
+			return ""
+		}
+		var inner string
+		if inner, err = expandGrokDepth(definition, patterns, captureTypes, captureNames, depth+1); err != nil {
+			return ""
+		}
+
+		if semantic == "" {
+			return "(?:" + inner + ")"
+		}
+		if grokType != "" {
+			fieldType, ok := grokTypes[grokType]
+			if !ok {
+				err = fmt.Errorf("grok: unknown type %q of %s", grokType, reference)
+				return ""
+			}
+			captureTypes[semantic] = fieldType
+		}
+		return "(?P<" + grokGroup(semantic, captureNames) + ">" + inner + ")"
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
+
+// grokGroup returns the name of the group of the semantic, the same for all
+// the references of it
+func grokGroup(semantic string, captureNames map[string]string) string {
+	if !strings.Contains(semantic, ".") {
+		return semantic
+	}
+	for group, field := range captureNames {
+		if field == semantic {
+			return group
+		}
+	}
+	group := fmt.Sprintf("%s%d", grokGroupPrefix, len(captureNames))
+	captureNames[group] = semantic
+	return group
+}
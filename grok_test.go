@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestGrokCombinedApacheLog(t *testing.T) {
+	fileconfig := &FileConfig{Grok: `%{COMBINEDAPACHELOG}`, ExactMatch: true}
+	chkerr(t, compilePattern(fileconfig))
+
+	event := newTestEvent(`10.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif?x=1 HTTP/1.0" 200 2326 "http://example.com/" "Mozilla/4.08 (Win98)"`)
+	event.PatternRegexp = fileconfig.PatternRegexp
+	event.CaptureTypes = fileconfig.CaptureTypes
+
+	decoded := decodeJsonFormat(t, event)
+	expected := map[string]string{
+		"clientip":    "10.0.0.1",
+		"auth":        "frank",
+		"timestamp":   "10/Oct/2000:13:55:36 -0700",
+		"verb":        "GET",
+		"request":     "/a.gif?x=1",
+		"httpversion": "1.0",
+		"response":    "200",
+		"bytes":       "2326",
+		"referrer":    `"http://example.com/"`,
+		"agent":       `"Mozilla/4.08 (Win98)"`,
+	}
+	for k, v := range expected {
+		if decoded[k] != v {
+			t.Errorf("Expected %s to be %q, got %v", k, v, decoded[k])
+		}
+	}
+}
+
+func TestGrokTypesAndPatternFiles(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	patternFile := path.Join(tmpdir, "patterns")
+	chkerr(t, ioutil.WriteFile(patternFile, []byte("# custom patterns\nREQID req-[0-9a-f]+\n"), 0644))
+
+	fileconfig := &FileConfig{
+		Grok:             `%{LOGLEVEL:level} %{REQID:reqid} took %{NUMBER:took:float}ms`,
+		GrokPatternFiles: []string{patternFile},
+	}
+	chkerr(t, compilePattern(fileconfig))
+
+	event := newTestEvent("WARN req-3f2a took 12.5ms")
+	event.PatternRegexp = fileconfig.PatternRegexp
+	event.CaptureTypes = fileconfig.CaptureTypes
+
+	decoded := decodeJsonFormat(t, event)
+	if decoded["level"] != "WARN" || decoded["reqid"] != "req-3f2a" || decoded["took"] != 12.5 {
+		t.Errorf("Unexpected fields: %v", decoded)
+	}
+
+	fileconfig = &FileConfig{Grok: `%{NOSUCHPATTERN:x}`}
+	if err := compilePattern(fileconfig); err == nil {
+		t.Errorf("Expected an error for an unknown grok pattern")
+	}
+}
+
+func TestGrokDottedNames(t *testing.T) {
+	fileconfig := &FileConfig{
+		Grok:         `(?:%{IPV4:client.ip}|\[%{IPV6:client.ip}\]) %{NUMBER:http.bytes:int} %{WORD:verb}`,
+		CaptureTypes: map[string]string{"verb": "string"},
+	}
+	chkerr(t, compilePattern(fileconfig))
+
+	for _, client := range []string{"10.0.0.1", "[::1]"} {
+		event := newTestEvent(client + " 2326 GET")
+		event.PatternRegexp = fileconfig.PatternRegexp
+		event.CaptureTypes = fileconfig.CaptureTypes
+		event.CaptureNames = fileconfig.CaptureNames
+
+		decoded := decodeJsonFormat(t, event)
+		if decoded["client.ip"] != strings.Trim(client, "[]") || decoded["http.bytes"] != float64(2326) || decoded["verb"] != "GET" {
+			t.Errorf("Unexpected fields: %v", decoded)
+		}
+	}
+
+	for _, grok := range []string{`%{IP:client ip}`, `%{IP:client:ip:int}`, `%{IP:.ip}`, `%{}`} {
+		if err := compilePattern(&FileConfig{Grok: grok}); err == nil {
+			t.Errorf("Expected an error for the malformed reference %s", grok)
+		}
+	}
+}
@@ -6,18 +6,25 @@ import (
 	"fmt"
 	"io"
 	"os" // for File and friends
-	"strings"
 	"time"
 )
 
 type Harvester struct {
-	Path            string /* the file path to harvest */
-	FileConfig      FileConfig
-	Offset          int64
-	FinishChan      chan int64
-	mergedBytesread int
-
-	file *os.File /* the file being watched */
+	Path       string /* the file path to harvest */
+	FileConfig FileConfig
+	Offset     int64
+	FinishChan chan int64
+
+	file      *os.File      /* the file being watched */
+	notify    chan struct{} /* wakes up the harvester when the file changes */
+	csv       *csvHeader
+	multiline *multilineBuffer
+	container *containerLog
+	archive   io.ReadCloser /* the decompressed file, if it is an archive */
+	pending   *FileEvent    /* the last event of the archive, held until its end */
+
+	fingerprint     string /* hash of the first fingerprintSize bytes read */
+	fingerprintSize int64
 }
 
 func (h *Harvester) Harvest(output chan *FileEvent) {
@@ -28,52 +35,83 @@ func (h *Harvester) Harvest(output chan *FileEvent) {
 	}
 	defer h.file.Close()
 
+	if h.Path != "-" {
+		notify := make(chan struct{}, 1)
+		if n := getNotifier(); n.subscribe(h.Path, false, notify) {
+			h.notify = notify
+			defer n.unsubscribe(h.Path, notify)
+		}
+	}
+
 	// On completion, push offset so we can continue where we left off if we relaunch on the same file
 	defer func() { h.FinishChan <- h.Offset }()
 
 	var line uint64 = 0 // Ask registrar about the line number
 
-	var multilineBuf []string
-	var multilineBufIndex int
 	if h.FileConfig.Multiline != nil {
-		multilineBuf = make([]string, h.FileConfig.Multiline.MaxLine)
-		multilineBufIndex = 0
+		h.multiline = &multilineBuffer{conf: h.FileConfig.Multiline}
 	}
 
-	// get current offset in file
-	offset, _ := h.file.Seek(0, os.SEEK_CUR)
-
-	if h.Offset > 0 {
-		emit("harvest: %q position:%d (offset snapshot:%d)\n", h.Path, h.Offset, offset)
-	} else if options.tailOnRotate {
-		emit("harvest: (tailing) %q (offset snapshot:%d)\n", h.Path, offset)
+	var input io.Reader = h.file
+	if h.FileConfig.isArchive(h.Path) {
+		// archives are read once from start to end, the offset is in the decompressed content
+		if err := h.openArchive(); err != nil {
+			emit("Failed decompressing %s: %s\n", h.Path, err)
+			return
+		}
+		defer h.archive.Close()
+		input = h.archive
+		emit("harvest: (archive) %q position:%d\n", h.Path, h.Offset)
 	} else {
-		emit("harvest: %q (offset snapshot:%d)\n", h.Path, offset)
+		// get current offset in file
+		offset, _ := h.file.Seek(0, os.SEEK_CUR)
+
+		if h.Offset > 0 {
+			emit("harvest: %q position:%d (offset snapshot:%d)\n", h.Path, h.Offset, offset)
+		} else if options.tailOnRotate {
+			emit("harvest: (tailing) %q (offset snapshot:%d)\n", h.Path, offset)
+		} else {
+			emit("harvest: %q (offset snapshot:%d)\n", h.Path, offset)
+		}
+
+		h.Offset = offset
 	}
 
-	h.Offset = offset
+	h.container = newContainerLog(&h.FileConfig, h.Path)
+	h.csv = newCSVHeader(&h.FileConfig)
+	if h.csv != nil && h.Offset > 0 {
+		h.csv.recover(h.Path, h.Offset)
+	}
 
-	reader := bufio.NewReaderSize(h.file, options.harvesterBufferSize) // 16kb buffer by default
+	reader := bufio.NewReaderSize(input, options.harvesterBufferSize) // 16kb buffer by default
 	buffer := new(bytes.Buffer)
 
 	var read_timeout = 10 * time.Second
 	last_read_time := time.Now()
 	var shouldReturn = false
-	var shouldMultiline = false
+	var archiveDone = false
 	for {
 		text, bytesread, err := h.readline(reader, buffer, read_timeout, h.FileConfig.MaxBytes)
-		h.mergedBytesread += bytesread
 
 		if err != nil {
 			if err == io.EOF {
 				// timed out waiting for data, got eof.
 				// Check to see if the file was truncated
 				info, _ := h.file.Stat()
-				if info.Size() < h.Offset {
+				if h.archive != nil {
+					emit("Finished harvest of archive %s\n", h.Path)
+					archiveDone = true
+					shouldReturn = true
+				} else if info.Size() < h.Offset {
 					emit("File truncated, seeking to beginning: %s\n", h.Path)
+					// the lines buffered end in the file as it was before
+					h.flushMultiline(output, &info, line)
 					h.file.Seek(0, os.SEEK_SET)
 					h.Offset = 0
-					shouldMultiline = true
+					h.fingerprint, h.fingerprintSize = "", 0
+					if h.csv != nil {
+						h.csv.reset(&h.FileConfig)
+					}
 				} else if age := time.Since(last_read_time); age > h.FileConfig.deadtime {
 					// if last_read_time was more than dead time, this file is probably
 					// dead. Stop watching it.
@@ -88,78 +126,43 @@ func (h *Harvester) Harvest(output chan *FileEvent) {
 			last_read_time = time.Now()
 		}
 
-		if h.FileConfig.Multiline != nil && (shouldReturn || shouldMultiline) {
-			h.sendEvent(multilineBuf, multilineBufIndex, output, &info, line)
+		if shouldReturn {
+			h.flushMultiline(output, &info, line)
 		} else if err == nil {
+			// container logs are unwrapped first, partial lines are held
+			// until the line is complete
+			if h.container != nil {
+				unwrapped, size, ok := h.container.unwrap(*text, bytesread)
+				if !ok {
+					continue
+				}
+				text, bytesread = &unwrapped, size
+			}
+
 			line++
 
-			if h.FileConfig.Multiline != nil {
-				match := h.FileConfig.Multiline.MatchRegexp.MatchString(*text)
-				if match {
-					if h.FileConfig.Multiline.Leader == true {
-						if multilineBufIndex > 0 {
-							h.sendEvent(multilineBuf, multilineBufIndex, output, &info, line)
-
-							multilineBuf[0] = *text
-							multilineBufIndex = 1
-						} else { // new leader
-							multilineBuf[0] = *text
-							multilineBufIndex = 1
-						}
-					} else {
-						multilineBuf[multilineBufIndex] = *text
-						multilineBufIndex++
-						if multilineBufIndex >= h.FileConfig.Multiline.MaxLine {
-							h.sendEvent(multilineBuf, multilineBufIndex, output, &info, line)
-							multilineBufIndex = 0
-						}
-					}
-				} else { // not match
-					if h.FileConfig.Multiline.Leader == true {
-						multilineBuf[multilineBufIndex] = *text
-						multilineBufIndex++
-						if multilineBufIndex >= h.FileConfig.Multiline.MaxLine {
-							h.sendEvent(multilineBuf, multilineBufIndex, output, &info, line)
-							multilineBufIndex = 0
-						}
-					} else { // follower
-						if multilineBufIndex > 0 {
-							h.sendEvent(multilineBuf, multilineBufIndex, output, &info, line)
-
-							multilineBuf[0] = *text
-							multilineBufIndex = 1
-						} else { // new leader
-							multilineBuf[0] = *text
-							multilineBufIndex = 1
-						}
-					}
+			// header rows and directives of csv codecs are not events
+			if h.csv != nil && h.csv.header(*text) {
+				// the bytes are in the multiline event being joined, if there is one
+				if h.multiline == nil || !h.multiline.skip(bytesread) {
+					h.Offset += int64(bytesread)
 				}
-			} else { // no multiline config
-				event := &FileEvent{
-					NoHostname:       h.FileConfig.NoHostname,
-					NoTimestamp:      h.FileConfig.NoTimestamp,
-					NoPath:           h.FileConfig.NoPath,
-					Hostname:         &h.FileConfig.Hostname,
-					MaxBytes:         h.FileConfig.MaxBytes,
-					Source:           &h.Path,
-					Offset:           h.Offset,
-					Line:             line,
-					Text:             text,
-					Fields:           &h.FileConfig.Fields,
-					FieldNames:       h.FileConfig.FieldNames,
-					DelimiterRegexp:  h.FileConfig.DelimiterRegexp,
-					ExactMatch:       h.FileConfig.ExactMatch,
-					QuoteChar:        h.FileConfig.QuoteChar,
-					FieldNamesLength: h.FileConfig.FieldNamesLength,
-					fileinfo:         &info,
-				}
-				h.Offset += int64(bytesread)
+				continue
+			}
 
-				output <- event // ship the new event downstream
+			if h.multiline != nil {
+				if merged, size, ok := h.multiline.add(*text, bytesread); ok {
+					h.sendEvent(merged, size, output, &info, line)
+				}
+			} else { // no multiline config
+				h.sendEvent(*text, bytesread, output, &info, line)
 			}
 		}
 
 		if shouldReturn {
+			if h.archive != nil {
+				h.finishArchive(output, archiveDone, &info)
+			}
 			return
 		}
 
@@ -189,7 +192,9 @@ func (h *Harvester) open() *os.File {
 	// Check we are not following a rabbit hole (symlinks, etc.)
 	mustBeRegularFile(h.file) // panics
 
-	if h.Offset > 0 {
+	if h.FileConfig.isArchive(h.Path) {
+		// archives are decompressed from their start
+	} else if h.Offset > 0 {
 		h.file.Seek(h.Offset, os.SEEK_SET)
 	} else if options.tailOnRotate {
 		h.file.Seek(0, os.SEEK_END)
@@ -203,6 +208,7 @@ func (h *Harvester) open() *os.File {
 func (h *Harvester) readline(reader *bufio.Reader, buffer *bytes.Buffer, eof_timeout time.Duration, maxBytes int) (*string, int, error) {
 	var is_partial bool = true
 	var newline_length int = 1
+	var notified bool = false
 	start_time := time.Now()
 
 	for {
@@ -234,7 +240,25 @@ func (h *Harvester) readline(reader *bufio.Reader, buffer *bytes.Buffer, eof_tim
 
 		if err != nil {
 			if err == io.EOF && is_partial {
-				time.Sleep(1 * time.Second) // TODO(sissel): Implement backoff
+				// Archives end at their end, the last line could have no newline
+				if h.archive != nil {
+					if buffer.Len() == 0 {
+						return nil, 0, err
+					}
+					bufferSize := buffer.Len()
+					str := new(string)
+					*str = buffer.String()
+					buffer.Reset()
+					return str, bufferSize, nil
+				}
+
+				// The file changed but nothing was appended, it could have been truncated
+				if notified && len(segment) == 0 {
+					return nil, 0, err
+				}
+
+				// Wait for the file to change, or poll it if it is not watched
+				notified = waitForChange(h.notify, 1*time.Second)
 
 				// Give up waiting for data after a certain amount of time.
 				// If we time out, return the error (eof)
@@ -278,33 +302,42 @@ func mustBeRegularFile(f *os.File) {
 	}
 }
 
-// sendEvent create a new event and send it ot output channel
-func (h *Harvester) sendEvent(multilineBuf []string, multilineBufIndex int,
-	output chan *FileEvent, info *os.FileInfo, line uint64) error {
-	mergedText := strings.Join(multilineBuf[:multilineBufIndex], "\n")
-	multilineBufIndex = 0
-
-	event := &FileEvent{
-		NoHostname:       h.FileConfig.NoHostname,
-		NoTimestamp:      h.FileConfig.NoTimestamp,
-		NoPath:           h.FileConfig.NoPath,
-		MaxBytes:         h.FileConfig.MaxBytes,
-		Hostname:         &h.FileConfig.Hostname,
-		Source:           &h.Path,
-		Offset:           h.Offset,
-		Line:             line,
-		Text:             &mergedText,
-		Fields:           &h.FileConfig.Fields,
-		FieldNames:       h.FileConfig.FieldNames,
-		DelimiterRegexp:  h.FileConfig.DelimiterRegexp,
-		ExactMatch:       h.FileConfig.ExactMatch,
-		QuoteChar:        h.FileConfig.QuoteChar,
-		FieldNamesLength: h.FileConfig.FieldNamesLength,
-		fileinfo:         info,
+// sendEvent create a new event of text and send it ot output channel. the
+// event ends size bytes after the offset, the lines dropped by include_lines
+// and exclude_lines only move the offset.
+func (h *Harvester) sendEvent(text string, size int, output chan *FileEvent, info *os.FileInfo, line uint64) {
+	if !h.FileConfig.keepLine(text) {
+		h.Offset += int64(size)
+		return
+	}
+
+	event := h.newEvent(&text, line, info)
+	h.Offset += int64(size)
+	event.EndOffset = h.Offset
+
+	h.send(event, output) // ship the new event downstream
+}
+
+// flushMultiline sends the multiline event being joined, if there is one
+func (h *Harvester) flushMultiline(output chan *FileEvent, info *os.FileInfo, line uint64) {
+	if h.multiline == nil {
+		return
+	}
+	if merged, size, ok := h.multiline.flush(); ok {
+		h.sendEvent(merged, size, output, info, line)
 	}
-	h.Offset += int64(h.mergedBytesread)
-	h.mergedBytesread = 0
+}
 
-	output <- event // ship the new event downstream
-	return nil
+// newEvent creates an event of text at the current offset, with the settings of the FileConfig
+func (h *Harvester) newEvent(text *string, line uint64, info *os.FileInfo) *FileEvent {
+	event := h.FileConfig.newEvent(&h.Path, text, h.Offset, line)
+	event.fileinfo = info
+	if h.csv != nil {
+		event.Columns = h.csv.columns
+	}
+	if h.container != nil {
+		event.Fields = h.container.streamFields(h.container.stream)
+		event.Time = h.container.time
+	}
+	return event
 }
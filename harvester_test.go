@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+type restartCase struct {
+	name       string
+	fileconfig FileConfig
+	content    string
+	// the events sent before the end of the file, the last multiline one is
+	// held until the harvest ends
+	texts []string
+}
+
+var restartCases = []restartCase{
+	{
+		name:    "lf",
+		content: "first\nsecond\nthird\n",
+		texts:   []string{"first", "second", "third"},
+	},
+	{
+		name:    "crlf",
+		content: "first\r\nsecond\r\n\r\nthird\r\n",
+		texts:   []string{"first", "second", "", "third"},
+	},
+	{
+		name:       "max_bytes",
+		fileconfig: FileConfig{MaxBytes: 8},
+		content:    "short\na line longer than max_bytes\nlast\n",
+		texts:      []string{"short", "a line longer than max_bytes\n", "last"},
+	},
+	{
+		name:       "exclude_lines",
+		fileconfig: FileConfig{ExcludeLines: []string{`^debug`}},
+		content:    "first\ndebug one\r\ndebug two\nsecond\ndebug three\nthird\n",
+		texts:      []string{"first", "second", "third"},
+	},
+	{
+		name: "multiline_follower",
+		fileconfig: FileConfig{Multiline: &MultilineConfig{
+			MatchRegexp: regexp.MustCompile(`^\s`), MaxLine: 10}},
+		content: "first\n  at a\n  at b\nsecond\r\n  at c\r\nthird\nfourth\n",
+		texts:   []string{"first\n  at a\n  at b", "second\n  at c", "third"},
+	},
+	{
+		name: "multiline_leader",
+		fileconfig: FileConfig{Multiline: &MultilineConfig{
+			MatchRegexp: regexp.MustCompile(`^\[`), Leader: true, MaxLine: 10}},
+		content: "[1] first\nmore\r\n[2] second\n[3] third\nmore\nmore\n[4] fourth\n",
+		texts:   []string{"[1] first\nmore", "[2] second", "[3] third\nmore\nmore"},
+	},
+	{
+		name: "multiline_max_line",
+		fileconfig: FileConfig{Multiline: &MultilineConfig{
+			MatchRegexp: regexp.MustCompile(`^\s`), MaxLine: 2}},
+		content: "first\n  at a\n  at b\n  at c\nsecond\n",
+		texts:   []string{"first\n  at a", "  at b\n  at c"},
+	},
+	{
+		name:       "docker_partial_lines",
+		fileconfig: FileConfig{Container: "docker"},
+		content: `{"log":"first ","stream":"stdout","time":"2019-03-01T10:00:00Z"}` + "\n" +
+			`{"log":"part\n","stream":"stdout","time":"2019-03-01T10:00:00Z"}` + "\n" +
+			`{"log":"second\r\n","stream":"stdout","time":"2019-03-01T10:00:01Z"}` + "\n" +
+			`{"log":"third\n","stream":"stdout","time":"2019-03-01T10:00:02Z"}` + "\n",
+		texts: []string{"first part", "second", "third"},
+	},
+}
+
+// harvestEvents harvests path from offset, until count events are sent
+func harvestEvents(t *testing.T, fileconfig FileConfig, path string, offset int64, count int) []*FileEvent {
+	output := make(chan *FileEvent, count+10)
+	h := &Harvester{Path: path, FileConfig: fileconfig, Offset: offset, FinishChan: make(chan int64, 1)}
+	go h.Harvest(output)
+
+	var events []*FileEvent
+	for len(events) < count {
+		select {
+		case event := <-output:
+			events = append(events, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %d events from offset %d, got %d", count, offset, len(events))
+		}
+	}
+	return events
+}
+
+// TestHarvesterRestart stops after each event, saves the registry and
+// resumes the harvest from it. the events after the restart must be the ones
+// sent by the harvest without restart.
+func TestHarvesterRestart(t *testing.T) {
+	for _, c := range restartCases {
+		tmpdir := makeTempDir(t)
+		path := filepath.Join(tmpdir, "app.log")
+		registry := filepath.Join(tmpdir, ".logstash-forwarder")
+		chkerr(t, ioutil.WriteFile(path, []byte(c.content), 0644))
+
+		fileconfig := c.fileconfig
+		if fileconfig.MaxBytes == 0 {
+			fileconfig.MaxBytes = 4096
+		}
+		chkerr(t, compileLineFilters(&fileconfig))
+
+		events := harvestEvents(t, fileconfig, path, 0, len(c.texts))
+		for i, event := range events {
+			if *event.Text != c.texts[i] {
+				t.Fatalf("%s: expected event %d to be %q, got %q", c.name, i, c.texts[i], *event.Text)
+			}
+			if event.EndOffset <= event.Offset || event.EndOffset > int64(len(c.content)) {
+				t.Fatalf("%s: unexpected bytes %d-%d for event %d", c.name, event.Offset, event.EndOffset, i)
+			}
+		}
+
+		for i := 1; i < len(events); i++ {
+			input := make(chan []*FileEvent, 1)
+			input <- events[:i]
+			close(input)
+			Registrar(make(map[string]*FileState), registry, nil, input)
+
+			state := loadRegistry(registry)[path]
+			if state == nil || state.Offset != events[i-1].EndOffset {
+				t.Fatalf("%s: expected the registry to resume at %d, got %+v", c.name, events[i-1].EndOffset, state)
+			}
+
+			resumed := harvestEvents(t, fileconfig, path, state.Offset, len(events)-i)
+			for j, event := range resumed {
+				expected := events[i+j]
+				if *event.Text != *expected.Text || event.Offset != expected.Offset || event.EndOffset != expected.EndOffset {
+					t.Fatalf("%s: restarted after %d events, expected %q at %d-%d, got %q at %d-%d", c.name, i,
+						*expected.Text, expected.Offset, expected.EndOffset, *event.Text, event.Offset, event.EndOffset)
+				}
+			}
+		}
+		rmTempDir(tmpdir)
+	}
+}
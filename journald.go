@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// JournaldConfig is the config of a journald input, which reads the journal in
+// the export format from `journalctl -o export --follow`. the settings of
+// FileConfig, like fields and codec, are applied to the MESSAGE of the entries.
+// multiline is not, journal entries are whole messages already.
+type JournaldConfig struct {
+	FileConfig
+	ID            string            `json:"id"`             // name of the input in the registry, default "default"
+	Command       string            `json:"command"`        // default journalctl
+	Units         []string          `json:"units"`          // only the entries of these systemd units
+	Matches       []string          `json:"matches"`        // journalctl matches, "_TRANSPORT=kernel"
+	Directory     string            `json:"directory"`      // read the journal files in this directory instead of the system journal
+	JournalFiles  []string          `json:"journal_files"`  // read these journal files instead of the system journal
+	FromBeginning bool              `json:"from_beginning"` // without a cursor in the registry, read the whole journal instead of only new entries
+	FieldMap      map[string]string `json:"field_map"`      // journal field to event field, added to journalFieldMap. "" drops the field
+	AllFields     bool              `json:"all_fields"`     // add the fields not mapped too, lowercased without the leading underscores
+}
+
+// journalFieldMap maps the journal fields to event fields by default. the
+// MESSAGE is the text of the event, and _HOSTNAME its host.
+var journalFieldMap = map[string]string{
+	"_SYSTEMD_UNIT":        "unit",
+	"_SYSTEMD_USER_UNIT":   "user_unit",
+	"PRIORITY":             "priority",
+	"SYSLOG_FACILITY":      "facility",
+	"SYSLOG_IDENTIFIER":    "identifier",
+	"_PID":                 "pid",
+	"_UID":                 "uid",
+	"_GID":                 "gid",
+	"_COMM":                "comm",
+	"_EXE":                 "exe",
+	"_CMDLINE":             "cmdline",
+	"_TRANSPORT":           "transport",
+	"_BOOT_ID":             "boot_id",
+	"_MACHINE_ID":          "machine_id",
+	"CONTAINER_NAME":       "container_name",
+	"__REALTIME_TIMESTAMP": "realtime_timestamp",
+}
+
+// the registry entry of a journald input is named journaldSourcePrefix + ID
+const journaldSourcePrefix = "journald://"
+
+// journal entries are at most this big, the same limit as journald's
+const journalMaxFieldSize = 768 << 20
+
+var errJournalExport = errors.New("invalid journal export format")
+
+func (jconf *JournaldConfig) source() string {
+	if jconf.ID == "" {
+		return journaldSourcePrefix + "default"
+	}
+	return journaldSourcePrefix + jconf.ID
+}
+
+// args are the arguments of journalctl, following the journal after cursor
+func (jconf *JournaldConfig) args(cursor string) []string {
+	args := []string{"-o", "export", "--follow"}
+	switch {
+	case cursor != "":
+		args = append(args, "--after-cursor="+cursor)
+	case jconf.FromBeginning:
+		args = append(args, "--lines=all")
+	default:
+		args = append(args, "--lines=0")
+	}
+	if jconf.Directory != "" {
+		args = append(args, "--directory="+jconf.Directory)
+	}
+	for _, file := range jconf.JournalFiles {
+		args = append(args, "--file="+file)
+	}
+	for _, unit := range jconf.Units {
+		args = append(args, "--unit="+unit)
+	}
+	return append(args, jconf.Matches...)
+}
+
+// readJournalEntry reads an entry of the export format. fields are "KEY=value"
+// lines, or the KEY line followed by the little endian uint64 size and the
+// binary value. entries end with an empty line.
+func readJournalEntry(reader *bufio.Reader) (map[string]string, error) {
+	entry := make(map[string]string)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && (line != "" || len(entry) > 0) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = line[:len(line)-1]
+		if line == "" {
+			if len(entry) == 0 {
+				// entries could be separated by more than one empty line
+				continue
+			}
+			return entry, nil
+		}
+
+		if eq := strings.IndexByte(line, '='); eq >= 0 {
+			entry[line[:eq]] = line[eq+1:]
+			continue
+		}
+
+		var size uint64
+		if err = binary.Read(reader, binary.LittleEndian, &size); err != nil {
+			return nil, err
+		}
+		if size > journalMaxFieldSize {
+			return nil, errJournalExport
+		}
+		value := make([]byte, size+1)
+		if _, err = io.ReadFull(reader, value); err != nil {
+			return nil, err
+		}
+		if value[size] != '\n' {
+			return nil, errJournalExport
+		}
+		entry[line] = string(value[:size])
+	}
+}
+
+// fieldName returns the event field of a journal field, or "" if it is not added
+func (jconf *JournaldConfig) fieldName(journalField string) string {
+	if name, ok := jconf.FieldMap[journalField]; ok {
+		return name
+	}
+	if name, ok := journalFieldMap[journalField]; ok {
+		return name
+	}
+	if jconf.AllFields && !strings.HasPrefix(journalField, "__") {
+		return strings.ToLower(strings.TrimLeft(journalField, "_"))
+	}
+	return ""
+}
+
+// newJournalEvent maps a journal entry to an event
+func (jconf *JournaldConfig) newJournalEvent(source *string, entry map[string]string, line uint64) *FileEvent {
+	text := entry["MESSAGE"]
+	if jconf.MaxBytes > 0 && len(text) > jconf.MaxBytes {
+		text = text[:jconf.MaxBytes]
+	}
+	event := jconf.newEvent(source, &text, 0, line)
+
+	fields := make(map[string]string, len(jconf.Fields)+len(journalFieldMap))
+	for k, v := range jconf.Fields {
+		fields[k] = v
+	}
+	for journalField, value := range entry {
+		if name := jconf.fieldName(journalField); name != "" {
+			if _, ok := fields[name]; !ok {
+				fields[name] = value
+			}
+		}
+	}
+	event.Fields = &fields
+
+	if hostname, ok := entry["_HOSTNAME"]; ok {
+		event.Hostname = &hostname
+	}
+	event.Cursor = entry["__CURSOR"]
+	return event
+}
+
+// readJournal sends the entries read from the export format to output. it
+// returns the cursor of the last entry read.
+func readJournal(input io.Reader, jconf *JournaldConfig, cursor string, output chan *FileEvent) (string, error) {
+	source := jconf.source()
+	reader := bufio.NewReader(input)
+	var line uint64
+	for {
+		entry, err := readJournalEntry(reader)
+		if err != nil {
+			if err == io.EOF {
+				return cursor, nil
+			}
+			return cursor, err
+		}
+		if message, ok := entry["MESSAGE"]; !ok || !jconf.keepLine(message) {
+			if entry["__CURSOR"] != "" {
+				cursor = entry["__CURSOR"]
+			}
+			continue
+		}
+
+		line++
+		event := jconf.newJournalEvent(&source, entry, line)
+		if event.Cursor != "" {
+			cursor = event.Cursor
+		}
+		output <- event
+	}
+}
+
+// ReadJournald follows the journal with journalctl from cursor, and starts
+// it again from the last entry read if it exits
+func ReadJournald(jconf *JournaldConfig, cursor string, output chan *FileEvent) {
+	command := jconf.Command
+	if command == "" {
+		command = "journalctl"
+	}
+
+	for {
+		cmd := exec.Command(command, jconf.args(cursor)...)
+		cmd.Stderr = os.Stderr
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			emit("journald %s: could not start %s: %s\n", jconf.source(), command, err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+		emit("journald %s: following the journal with %s %s\n", jconf.source(), command, strings.Join(cmd.Args[1:], " "))
+
+		cursor, err = readJournal(stdout, jconf, cursor, output)
+		if err != nil {
+			emit("journald %s: %s\n", jconf.source(), err)
+			cmd.Process.Kill()
+		}
+		err = cmd.Wait()
+		emit("journald %s: %s exited (%v), starting it again\n", jconf.source(), command, err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// journaldDefaults sets the defaults of a journald input loaded from the config
+func journaldDefaults(jconf *JournaldConfig) error {
+	if jconf.MaxBytes == 0 {
+		jconf.MaxBytes = 1024 * 1024
+	}
+	if jconf.Multiline != nil {
+		return fmt.Errorf("journald %s: multiline is not supported", jconf.source())
+	}
+	// @timestamp is the time of the entry, not when it was read
+	if jconf.Timestamp == nil && jconf.fieldName("__REALTIME_TIMESTAMP") != "" {
+		jconf.Timestamp = &TimestampConfig{Field: jconf.fieldName("__REALTIME_TIMESTAMP"), Layouts: []string{"UNIX_US"}}
+	}
+	if jconf.Hostname == "" {
+		jconf.Hostname, _ = os.Hostname()
+	}
+	return nil
+}
+
+// journaldStates are the registry entries of the journald inputs, to persist
+// them before the inputs read anything new
+func journaldStates(config *Config, files map[string]*FileState) map[string]*FileState {
+	states := make(map[string]*FileState)
+	for idx := range config.Journald {
+		source := config.Journald[idx].source()
+		if state, ok := files[source]; ok && state.Cursor != "" {
+			states[source] = state
+		}
+	}
+	return states
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestReadJournal(t *testing.T) {
+	fixture, err := os.Open("testdata/journal.export")
+	chkerr(t, err)
+	defer fixture.Close()
+
+	jconf := &JournaldConfig{ID: "system"}
+	jconf.Fields = map[string]string{"type": "journal"}
+	jconf.NoTimestamp = false
+	chkerr(t, journaldDefaults(jconf))
+	chkerr(t, splitFileConfig(&jconf.FileConfig))
+
+	events := make(chan *FileEvent, 16)
+	cursor, err := readJournal(fixture, jconf, "", events)
+	chkerr(t, err)
+	close(events)
+
+	// the last entry has no MESSAGE, it only moves the cursor
+	if cursor != "s=a1;i=3" {
+		t.Errorf("Expected the cursor of the last entry, got %q", cursor)
+	}
+
+	var received []*FileEvent
+	for event := range events {
+		received = append(received, event)
+	}
+	if len(received) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(received))
+	}
+
+	event := received[0]
+	if *event.Source != "journald://system" || event.Cursor != "s=a1;i=1" {
+		t.Errorf("Unexpected source %s and cursor %s", *event.Source, event.Cursor)
+	}
+	decoded := decodeJsonFormat(t, event)
+	expected := map[string]interface{}{
+		"message":    "started",
+		"unit":       "nginx.service",
+		"priority":   "6",
+		"pid":        "812",
+		"identifier": "nginx",
+		"boot_id":    "b00t",
+		"host":       "web1",
+		"type":       "journal",
+		"@timestamp": float64(1420167845123),
+	}
+	for k, v := range expected {
+		if decoded[k] != v {
+			t.Errorf("Expected %s to be %#v, got %#v", k, v, decoded[k])
+		}
+	}
+
+	// binary fields keep their newlines
+	if *received[1].Text != "panic: oops\n\tat main.go:1" {
+		t.Errorf("Unexpected binary MESSAGE %q", *received[1].Text)
+	}
+	if _, ok := (*received[1].Fields)["code_file"]; ok {
+		t.Errorf("Expected CODE_FILE not mapped without all_fields")
+	}
+}
+
+func TestJournaldRegistrar(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+	wd, _ := os.Getwd()
+	chkerr(t, os.Chdir(tmpdir))
+	defer os.Chdir(wd)
+
+	jconf := &JournaldConfig{ID: "system", FieldMap: map[string]string{"CODE_FILE": "code_file"}}
+	source := jconf.source()
+	event := jconf.newJournalEvent(&source, map[string]string{"MESSAGE": "hi", "__CURSOR": "s=a1;i=9", "CODE_FILE": "main.go"}, 1)
+	if (*event.Fields)["code_file"] != "main.go" {
+		t.Errorf("Expected CODE_FILE mapped by field_map, got %v", *event.Fields)
+	}
+
+	state := make(map[string]*FileState)
+	input := make(chan []*FileEvent, 1)
+	input <- []*FileEvent{event}
+	close(input)
+	Registrar(state, ".logstash-forwarder", nil, input)
+
+	if state[source] == nil || state[source].Cursor != "s=a1;i=9" {
+		t.Fatalf("Expected the cursor in the registrar state, got %v", state[source])
+	}
+	resumed := journaldStates(&Config{Journald: []JournaldConfig{*jconf}}, state)
+	if resumed[source] == nil || jconf.args(resumed[source].Cursor)[3] != "--after-cursor=s=a1;i=9" {
+		t.Fatalf("Expected journalctl to follow after the cursor, got %v", resumed)
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -118,98 +120,252 @@ func (e *encodeState) string(s string) (int, error) {
 	return e.Len() - len0, nil
 }
 
+// key writes the key of the next member, with the comma if it is not the first one
+func (e *encodeState) key(k string) {
+	if e.Len() > 1 {
+		e.WriteByte(',')
+	}
+	e.WriteByte('"')
+	e.string(k)
+	e.WriteByte('"')
+	e.WriteByte(':')
+}
+
+// quoted writes s as a json string
+func (e *encodeState) quoted(s string) {
+	e.WriteByte('"')
+	e.string(s)
+	e.WriteByte('"')
+}
+
+// tag added to the event if a split field could not be converted to its FieldTypes
+const fieldTypeFailureTag = "_fieldtypefailure"
+
+// convertField converts value to the json literal of fieldType, see FieldTypes in FileConfig
+func convertField(value string, fieldType string) (string, error) {
+	switch {
+	case fieldType == "" || fieldType == "string":
+		e := &encodeState{}
+		e.quoted(value)
+		return e.String(), nil
+	case fieldType == "integer":
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(i, 10), nil
+	case fieldType == "float":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", err
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return "", fmt.Errorf("%q is not a valid json number", value)
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	case fieldType == "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatBool(b), nil
+	case strings.HasPrefix(fieldType, "timestamp:"):
+		t, err := time.Parse(strings.TrimPrefix(fieldType, "timestamp:"), value)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(t.UnixNano()/1000000, 10), nil
+	}
+	return "", fmt.Errorf("unknown field type %q", fieldType)
+}
+
+// formatState is the state of JsonFormat2 while the fields of an event are written
+type formatState struct {
+	encodeState
+	// raw values of the fields parsed from the text, by name
+	values map[string]string
+	// keys of Fields, host, path and @timestamp already written by the codec
+	overwritten map[string]bool
+	tags        []string
+	// tags decoded from the text, written before tags in the same array
+	decodedTags []json.RawMessage
+	// the codec could not parse the text, the timestamp is not parsed either
+	codecFailed bool
+}
+
+func (f *formatState) tag(tag string) {
+	for _, t := range f.tags {
+		if t == tag {
+			return
+		}
+	}
+	f.tags = append(f.tags, tag)
+}
+
+// typedField writes value converted to fieldType, values which could not be
+// converted are handled by ConversionFailure
+func (f *formatState) typedField(event *FileEvent, name string, value string, fieldType string) {
+	f.values[name] = value
+	literal, err := convertField(value, fieldType)
+	if err != nil {
+		switch event.ConversionFailure {
+		case "drop":
+			return
+		case "tag":
+			f.tag(fieldTypeFailureTag)
+		}
+		literal, _ = convertField(value, "string")
+	}
+	f.key(name)
+	f.WriteString(literal)
+}
+
+// splitFields writes the split values with their FieldNames. consecutive fields
+// with the same name are joined with a space. values are converted to their
+// FieldTypes, and the failed ones are handled by ConversionFailure.
+func (f *formatState) splitFields(event *FileEvent, splited []string) {
+	for idx := 0; idx < len(event.FieldNames); idx++ {
+		fieldname := event.FieldNames[idx]
+		value := strings.Trim(splited[idx], event.QuoteChar)
+		fieldType := ""
+		if len(event.FieldTypes) > 0 {
+			fieldType = event.FieldTypes[idx]
+		}
+		for idx+1 < len(event.FieldNames) && event.FieldNames[idx+1] == fieldname {
+			idx++
+			value += " " + strings.Trim(splited[idx], event.QuoteChar)
+		}
+
+		f.typedField(event, fieldname, value, fieldType)
+	}
+}
+
+// tag added to the event if its text does not match the Pattern
+const patternFailureTag = "_patternparsefailure"
+
+// patternFields writes the named groups of Pattern matched in the text. it
+// returns false if the text does not match.
+func (f *formatState) patternFields(event *FileEvent) bool {
+	text := *event.Text
+	match := event.PatternRegexp.FindStringSubmatchIndex(text)
+	if match == nil {
+		if event.PatternFailure == "tag" {
+			f.tag(patternFailureTag)
+		}
+		return false
+	}
+
+	for i, name := range event.PatternRegexp.SubexpNames() {
+		// skip unnamed groups and optional groups which did not match, a group
+		// matching an empty string is kept. a name could be used by more than
+		// one group, the first match wins.
+		if name == "" || match[2*i] < 0 {
+			continue
+		}
+		if field, ok := event.CaptureNames[name]; ok {
+			name = field
+		}
+		if _, ok := f.values[name]; ok {
+			continue
+		}
+		f.typedField(event, name, text[match[2*i]:match[2*i+1]], event.CaptureTypes[name])
+	}
+	return true
+}
+
 // use string func. we do not need to format complex struct, only map[string]string, so string func could meet our needs
 func JsonFormat2(event *FileEvent) string {
-	e := &encodeState{}
+	e := &formatState{values: make(map[string]string), overwritten: make(map[string]bool)}
 
 	e.WriteByte('{')
 
-	if len(event.FieldNames) == 0 {
-		e.WriteString("\"message\"")
-		e.WriteByte(':')
-		e.WriteByte('"')
-		e.string(*event.Text)
-		e.WriteByte('"')
+	if event.Codec == "json" {
+		if !e.jsonFields(event) {
+			e.codecFailed = true
+			e.key("message")
+			e.quoted(*event.Text)
+		}
+	} else if event.Codec == "logfmt" || event.Codec == "kv" {
+		if !e.kvFields(event) {
+			e.codecFailed = true
+			e.key("message")
+			e.quoted(*event.Text)
+		}
+	} else if event.Codec == "syslog" {
+		if !e.syslogFields(event) {
+			e.codecFailed = true
+			e.key("message")
+			e.quoted(*event.Text)
+		}
+	} else if isCSVCodec(event.Codec) {
+		if !e.csvFields(event) {
+			e.codecFailed = true
+			e.key("message")
+			e.quoted(*event.Text)
+		}
+	} else if event.PatternRegexp != nil {
+		if !e.patternFields(event) {
+			e.key("message")
+			e.quoted(*event.Text)
+		}
+	} else if len(event.FieldNames) == 0 {
+		e.key("message")
+		e.quoted(*event.Text)
 	} else {
 		splited := event.DelimiterRegexp.Split(strings.TrimSpace(*event.Text), -1)
 		if len(splited) == event.FieldNamesLength {
-			for idx, fieldname := range event.FieldNames {
-				if idx > 0 && fieldname == event.FieldNames[idx-1] {
-					e.string(" " + strings.Trim(splited[idx], event.QuoteChar))
-				} else {
-					if idx > 0 {
-						e.WriteByte('"')
-						e.WriteByte(',')
-					}
-					e.WriteString("\"" + fieldname + "\"")
-					e.WriteByte(':')
-					e.WriteByte('"')
-					e.string(strings.Trim(splited[idx], event.QuoteChar))
-				}
-			}
-			e.WriteByte('"')
+			e.splitFields(event, splited)
 		} else {
-			e.WriteString("\"message\"")
-			e.WriteByte(':')
-			e.WriteByte('"')
-			e.string(*event.Text)
-			e.WriteByte('"')
+			e.key("message")
+			e.quoted(*event.Text)
 			if event.ExactMatch == false && len(splited) > event.FieldNamesLength {
-				e.WriteByte(',')
-				for idx, fieldname := range event.FieldNames {
-					if idx > 0 && fieldname == event.FieldNames[idx-1] {
-						e.string(" " + strings.Trim(splited[idx], event.QuoteChar))
-					} else {
-						if idx > 0 {
-							e.WriteByte('"')
-							e.WriteByte(',')
-						}
-						e.WriteString("\"" + fieldname + "\"")
-						e.WriteByte(':')
-						e.WriteByte('"')
-						e.string(strings.Trim(splited[idx], event.QuoteChar))
-					}
-				}
-				e.WriteByte('"')
-				//for idx, fieldname := range event.FieldNames {
-				//e.WriteByte(',')
-				//e.WriteString("\"" + fieldname + "\"")
-				//e.WriteByte(':')
-				//e.string(strings.Trim(splited[idx], event.QuoteChar))
-				//}
+				e.splitFields(event, splited)
 			}
 		}
 	}
 
 	// dump Fields into json string
 	for k, v := range *event.Fields {
-		e.WriteByte(',')
-		e.WriteString("\"" + k + "\"")
-		e.WriteByte(':')
-		e.WriteString("\"" + v + "\"")
+		if e.overwritten[k] {
+			continue
+		}
+		e.key(k)
+		e.quoted(v)
 	}
 
-	if event.NoHostname == false {
-		e.WriteByte(',')
-		e.WriteString("\"host\"")
-		e.WriteByte(':')
-		e.WriteString("\"" + *event.Hostname + "\"")
+	if event.NoHostname == false && !e.overwritten["host"] {
+		e.key("host")
+		e.quoted(*event.Hostname)
 	}
 
-	if event.NoPath == false {
-		e.WriteByte(',')
-		e.WriteString("\"path\"")
-		e.WriteByte(':')
-		e.WriteByte('"')
-		e.string(*event.Source)
-		e.WriteByte('"')
+	if event.NoPath == false && !e.overwritten["path"] {
+		e.key("path")
+		e.quoted(*event.Source)
 	}
 
-	if event.NoTimestamp == false {
-		e.WriteByte(',')
-		e.WriteString("\"@timestamp\"")
-		e.WriteByte(':')
-		e.WriteString(strconv.FormatInt(time.Now().UnixNano()/1000000, 10))
+	if event.NoTimestamp == false && !e.overwritten["@timestamp"] {
+		if timestamp, ok := e.timestamp(event); ok {
+			e.key("@timestamp")
+			e.WriteString(strconv.FormatInt(timestamp.UnixNano()/1000000, 10))
+		}
+	}
+
+	if len(e.decodedTags) > 0 || len(e.tags) > 0 {
+		e.key("tags")
+		e.WriteByte('[')
+		for i, tag := range e.decodedTags {
+			if i > 0 {
+				e.WriteByte(',')
+			}
+			e.Write(tag)
+		}
+		for i, tag := range e.tags {
+			if i > 0 || len(e.decodedTags) > 0 {
+				e.WriteByte(',')
+			}
+			e.quoted(tag)
+		}
+		e.WriteByte(']')
 	}
 
 	e.WriteByte('}')
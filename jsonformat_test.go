@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+)
+
+func newTestEvent(text string) *FileEvent {
+	source := "/var/log/test.log"
+	hostname := "testhost"
+	fields := map[string]string{"type": "test"}
+	return &FileEvent{
+		Source:          &source,
+		Text:            &text,
+		Hostname:        &hostname,
+		Fields:          &fields,
+		DelimiterRegexp: regexp.MustCompile(`\s+`),
+		NoTimestamp:     true,
+	}
+}
+
+func decodeJsonFormat(t *testing.T, event *FileEvent) map[string]interface{} {
+	msg := JsonFormat(event)
+	decoded := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(msg), &decoded); err != nil {
+		t.Fatalf("JsonFormat gave invalid json %s: %s", msg, err)
+	}
+	return decoded
+}
+
+func TestJsonFormatFieldTypes(t *testing.T) {
+	event := newTestEvent(`2015-01-02 GET /index 200 0.25 true "a b"`)
+	event.FieldNames = []string{"date", "method", "uri", "status", "time_taken", "cached", "agent"}
+	event.FieldTypes = []string{"timestamp:2006-01-02", "string", "string", "integer", "float", "boolean", "string"}
+	event.FieldNamesLength = len(event.FieldNames)
+	event.QuoteChar = `"`
+	event.ExactMatch = false
+
+	decoded := decodeJsonFormat(t, event)
+	if decoded["date"] != float64(1420156800000) {
+		t.Errorf("Expected date converted to epoch millis, got %v", decoded["date"])
+	}
+	if decoded["status"] != float64(200) || decoded["time_taken"] != 0.25 || decoded["cached"] != true {
+		t.Errorf("Unexpected converted fields: %v", decoded)
+	}
+	if decoded["method"] != "GET" || decoded["type"] != "test" || decoded["host"] != "testhost" {
+		t.Errorf("Unexpected string fields: %v", decoded)
+	}
+}
+
+func TestJsonFormatConversionFailure(t *testing.T) {
+	for _, policy := range []string{"keep", "drop", "tag"} {
+		event := newTestEvent("GET -")
+		event.FieldNames = []string{"method", "status"}
+		event.FieldTypes = []string{"string", "integer"}
+		event.FieldNamesLength = len(event.FieldNames)
+		event.ConversionFailure = policy
+
+		decoded := decodeJsonFormat(t, event)
+		status, hasStatus := decoded["status"]
+		_, hasTags := decoded["tags"]
+		switch policy {
+		case "keep":
+			if status != "-" || hasTags {
+				t.Errorf("keep: expected status kept as string without tags, got %v", decoded)
+			}
+		case "drop":
+			if hasStatus || hasTags {
+				t.Errorf("drop: expected status dropped without tags, got %v", decoded)
+			}
+		case "tag":
+			if status != "-" || !hasTags {
+				t.Errorf("tag: expected status kept as string and tags, got %v", decoded)
+			}
+		}
+	}
+}
+
+func TestJsonFormatPattern(t *testing.T) {
+	fileconfig := &FileConfig{
+		Pattern:        `^(?P<client>\S+) \S+ \S+ \[(?P<time>[^\]]+)\] "(?P<request>[^"]*)" (?P<status>\d+) (?P<bytes>\d+|-)(?: "(?P<referrer>[^"]*)")?`,
+		CaptureTypes:   map[string]string{"status": "integer"},
+		PatternFailure: "tag",
+	}
+	chkerr(t, compilePattern(fileconfig))
+
+	event := newTestEvent(`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`)
+	event.PatternRegexp = fileconfig.PatternRegexp
+	event.CaptureTypes = fileconfig.CaptureTypes
+	event.PatternFailure = fileconfig.PatternFailure
+
+	decoded := decodeJsonFormat(t, event)
+	if decoded["client"] != "127.0.0.1" || decoded["request"] != "GET /apache_pb.gif HTTP/1.0" || decoded["status"] != float64(200) {
+		t.Errorf("Unexpected fields: %v", decoded)
+	}
+	if _, ok := decoded["referrer"]; ok {
+		t.Errorf("Expected no referrer for an unmatched optional group, got %v", decoded["referrer"])
+	}
+	if _, ok := decoded["message"]; ok {
+		t.Errorf("Expected no message for a matched line, got %v", decoded["message"])
+	}
+
+	// groups matching an empty string are fields
+	text := `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "" 400 0 ""`
+	event.Text = &text
+	decoded = decodeJsonFormat(t, event)
+	if request, ok := decoded["request"]; !ok || request != "" || decoded["referrer"] != "" {
+		t.Errorf("Expected empty request and referrer, got %v", decoded)
+	}
+
+	text = "not an access log line"
+	event.Text = &text
+	decoded = decodeJsonFormat(t, event)
+	if decoded["message"] != text {
+		t.Errorf("Expected the unmatched line as message, got %v", decoded["message"])
+	}
+	if tags, _ := decoded["tags"].([]interface{}); len(tags) != 1 || tags[0] != patternFailureTag {
+		t.Errorf("Expected %s tag, got %v", patternFailureTag, decoded["tags"])
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KubernetesConfig discovers the pod logs on a kubernetes node. the files are
+// harvested as CRI container logs, with the namespace, pod, pod_uid and
+// container parsed from the path in the fields. the labels of the pod are
+// added as pod_label_<name> if URL is set. kafka topic_id and key could use
+// them, "logs-{{.namespace}}".
+// url: kubelet pods endpoint, "https://127.0.0.1:10250/pods", or the apiserver, "https://kubernetes.default.svc"
+// api: kubelet (default) or apiserver
+// token_file: bearer token sent to url, the service account token for example
+// ca_file: ca of the certificate of url
+// insecure_skip_verify: do not verify the certificate of url, kubelets have self signed ones
+// timeout: seconds to wait for url, default 10
+// logs_path: default /var/log/pods
+type KubernetesConfig struct {
+	URL                string `json:"url"`
+	API                string `json:"api"`
+	TokenFile          string `json:"token_file"`
+	CAFile             string `json:"ca_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	Timeout            int64  `json:"timeout"`
+	LogsPath           string `json:"logs_path"`
+
+	client *http.Client
+	token  string
+	mutex  sync.Mutex
+	// labels of the pods by uid, from the last list of the kubelet
+	labels map[string]map[string]string
+}
+
+// <logs_path>/<namespace>_<pod>_<uid>/<container>/<restart count>.log
+var podLogPath = regexp.MustCompile(`([^/_]+)_([^/_]+)_([^/_]+)/([^/]+)/[^/]+\.log$`)
+
+// kubernetesPod is the part of a pod of the kubernetes api we need
+type kubernetesPod struct {
+	Metadata struct {
+		Name      string            `json:"name"`
+		Namespace string            `json:"namespace"`
+		UID       string            `json:"uid"`
+		Labels    map[string]string `json:"labels"`
+	} `json:"metadata"`
+}
+
+type kubernetesPodList struct {
+	Items []kubernetesPod `json:"items"`
+}
+
+func (kconf *KubernetesConfig) compile(fileconfig *FileConfig) error {
+	switch kconf.API {
+	case "", "kubelet", "apiserver":
+	default:
+		return fmt.Errorf("kubernetes: unknown api %q, should be kubelet or apiserver", kconf.API)
+	}
+	if kconf.LogsPath == "" {
+		kconf.LogsPath = "/var/log/pods"
+	}
+	if len(fileconfig.Paths) == 0 {
+		fileconfig.Paths = []string{filepath.Join(kconf.LogsPath, "*_*_*", "*", "*.log")}
+	}
+	if fileconfig.Container == "" {
+		fileconfig.Container = "auto"
+	}
+	if kconf.URL == "" || kconf.client != nil {
+		return nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: kconf.InsecureSkipVerify}
+	if kconf.CAFile != "" {
+		pemCerts, err := ioutil.ReadFile(kconf.CAFile)
+		if err != nil {
+			return fmt.Errorf("kubernetes: failed reading ca file: %s", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pemCerts) {
+			return fmt.Errorf("kubernetes: no certificate found in %s", kconf.CAFile)
+		}
+	}
+	if kconf.TokenFile != "" {
+		token, err := ioutil.ReadFile(kconf.TokenFile)
+		if err != nil {
+			return fmt.Errorf("kubernetes: failed reading token file: %s", err)
+		}
+		kconf.token = strings.TrimSpace(string(token))
+	}
+	timeout := kconf.Timeout
+	if timeout == 0 {
+		timeout = 10
+	}
+	kconf.client = &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		Timeout:   time.Duration(timeout) * time.Second,
+	}
+	return nil
+}
+
+func (kconf *KubernetesConfig) get(url string, v interface{}) error {
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if kconf.token != "" {
+		request.Header.Set("Authorization", "Bearer "+kconf.token)
+	}
+	response, err := kconf.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+// podLabels returns the labels of the pod. the kubelet lists all the pods of
+// the node, the list is fetched again only for pods not in the last one.
+func (kconf *KubernetesConfig) podLabels(namespace, name, uid string) (map[string]string, error) {
+	kconf.mutex.Lock()
+	defer kconf.mutex.Unlock()
+
+	if labels, ok := kconf.labels[uid]; ok {
+		return labels, nil
+	}
+
+	if kconf.API == "apiserver" {
+		var pod kubernetesPod
+		podURL := fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s", strings.TrimRight(kconf.URL, "/"), url.PathEscape(namespace), url.PathEscape(name))
+		if err := kconf.get(podURL, &pod); err != nil {
+			return nil, err
+		}
+		if kconf.labels == nil {
+			kconf.labels = make(map[string]map[string]string)
+		}
+		kconf.labels[uid] = pod.Metadata.Labels
+		return pod.Metadata.Labels, nil
+	}
+
+	var pods kubernetesPodList
+	if err := kconf.get(kconf.URL, &pods); err != nil {
+		return nil, err
+	}
+	kconf.labels = make(map[string]map[string]string, len(pods.Items))
+	for _, pod := range pods.Items {
+		kconf.labels[pod.Metadata.UID] = pod.Metadata.Labels
+	}
+	if labels, ok := kconf.labels[uid]; ok {
+		return labels, nil
+	}
+	return nil, fmt.Errorf("pod %s/%s (%s) is not on this node", namespace, name, uid)
+}
+
+// podMetadata returns the fields of the pod whose log is path. the labels
+// are read when the harvest of the file starts.
+func (kconf *KubernetesConfig) podMetadata(path string) map[string]string {
+	metadata := make(map[string]string)
+	m := podLogPath.FindStringSubmatch(filepath.ToSlash(path))
+	if m == nil {
+		return metadata
+	}
+	metadata["namespace"] = m[1]
+	metadata["pod"] = m[2]
+	metadata["pod_uid"] = m[3]
+	metadata["container_name"] = m[4]
+
+	if kconf.client == nil {
+		return metadata
+	}
+	labels, err := kconf.podLabels(m[1], m[2], m[3])
+	if err != nil {
+		emit("Could not get the labels of pod %s/%s: %s\n", m[1], m[2], err)
+		return metadata
+	}
+	for k, v := range labels {
+		metadata["pod_label_"+k] = v
+	}
+	return metadata
+}
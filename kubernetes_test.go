@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+)
+
+func TestKubernetesPodMetadata(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var pods kubernetesPodList
+		pods.Items = make([]kubernetesPod, 1)
+		pods.Items[0].Metadata.Name = "web-1"
+		pods.Items[0].Metadata.Namespace = "shop"
+		pods.Items[0].Metadata.UID = "6d1c"
+		pods.Items[0].Metadata.Labels = map[string]string{"app": "web"}
+		json.NewEncoder(w).Encode(pods)
+	}))
+	defer server.Close()
+
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+	tokenFile := tmpdir + "/token"
+	chkerr(t, ioutil.WriteFile(tokenFile, []byte("s3cret\n"), 0600))
+
+	fileconfig := &FileConfig{Kubernetes: &KubernetesConfig{URL: server.URL + "/pods", TokenFile: tokenFile}}
+	chkerr(t, splitFileConfig(fileconfig))
+	if fileconfig.Paths[0] != "/var/log/pods/*_*_*/*/*.log" || fileconfig.Container != "auto" {
+		t.Fatalf("Unexpected defaults: %v %s", fileconfig.Paths, fileconfig.Container)
+	}
+
+	c := newContainerLog(fileconfig, "/var/log/pods/shop_web-1_6d1c/nginx/0.log")
+	expected := map[string]string{
+		"namespace":      "shop",
+		"pod":            "web-1",
+		"pod_uid":        "6d1c",
+		"container_name": "nginx",
+		"pod_label_app":  "web",
+	}
+	for k, v := range expected {
+		if c.base[k] != v {
+			t.Errorf("Expected %s to be %q, got %q", k, v, c.base[k])
+		}
+	}
+
+	// the pods listed are cached, and the topic could use the namespace
+	c = newContainerLog(fileconfig, "/var/log/pods/shop_web-1_6d1c/sidecar/0.log")
+	if requests != 1 || c.base["pod_label_app"] != "web" {
+		t.Errorf("Expected the labels from the cache, got %d requests", requests)
+	}
+	topic := template.Must(template.New("topic").Parse("logs-{{.namespace}}"))
+	buf := &encodeState{}
+	chkerr(t, topic.Execute(buf, c.streamFields("stdout")))
+	if buf.String() != "logs-shop" {
+		t.Errorf("Unexpected topic %q", buf.String())
+	}
+}
+
+func TestKubernetesAPIServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/kube-system/pods/dns-7f" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"metadata": {"name": "dns-7f", "namespace": "kube-system", "uid": "aa11", "labels": {"k8s-app": "kube-dns"}}}`))
+	}))
+	defer server.Close()
+
+	kconf := &KubernetesConfig{URL: server.URL, API: "apiserver"}
+	chkerr(t, kconf.compile(&FileConfig{}))
+	metadata := kconf.podMetadata("/var/log/pods/kube-system_dns-7f_aa11/coredns/1.log")
+	if metadata["pod_label_k8s-app"] != "kube-dns" || metadata["namespace"] != "kube-system" {
+		t.Errorf("Unexpected metadata: %v", metadata)
+	}
+}
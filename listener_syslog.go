@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ListenerConfig is the config of a network syslog listener. the settings of
+// FileConfig, like fields, codec and multiline, are applied to the received
+// messages as they are to the lines of files. codec is syslog by default.
+type ListenerConfig struct {
+	FileConfig
+	Protocol       string `json:"protocol"`        // udp, tcp or tls
+	Listen         string `json:"listen"`          // "0.0.0.0:514"
+	Framing        string `json:"framing"`         // tcp and tls messages are split by newline (default) or octet-counting
+	SSLCertificate string `json:"ssl certificate"` // server certificate, tls only
+	SSLKey         string `json:"ssl key"`         // server key
+	SSLCA          string `json:"ssl ca"`          // if set, clients must present a certificate signed by it
+}
+
+// octet-counting frames have at most this many digits, RFC 6587 3.4.1
+const syslogMaxFrameDigits = 10
+
+var errSyslogFrame = errors.New("invalid octet-counting frame")
+
+// udp senders without messages for syslogSenderIdle are forgotten, and at most
+// syslogMaxSenders are kept, each of them has a goroutine
+var (
+	syslogSenderIdle = time.Minute
+	syslogMaxSenders = 1024
+)
+
+func (lconf *ListenerConfig) check() error {
+	if lconf.Listen == "" {
+		return errors.New("listener: listen is required")
+	}
+	switch lconf.Protocol {
+	case "udp", "tcp":
+	case "tls":
+		if lconf.SSLCertificate == "" || lconf.SSLKey == "" {
+			return fmt.Errorf("listener %s: tls needs ssl certificate and ssl key", lconf.Listen)
+		}
+	default:
+		return fmt.Errorf("listener %s: unknown protocol %q, should be udp, tcp or tls", lconf.Listen, lconf.Protocol)
+	}
+	switch lconf.Framing {
+	case "", "newline", "octet-counting":
+	default:
+		return fmt.Errorf("listener %s: unknown framing %q, should be newline or octet-counting", lconf.Listen, lconf.Framing)
+	}
+	return nil
+}
+
+func (lconf *ListenerConfig) listen() (net.Listener, error) {
+	if lconf.Protocol != "tls" {
+		return net.Listen("tcp", lconf.Listen)
+	}
+
+	tlsConfig, err := serverTLSConfig(lconf.SSLCertificate, lconf.SSLKey, lconf.SSLCA)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Listen("tcp", lconf.Listen, tlsConfig)
+}
+
+// ListenSyslog receives syslog messages on the listener and sends them to
+// output as events
+func ListenSyslog(lconf *ListenerConfig, output chan *FileEvent) {
+	if lconf.Protocol == "udp" {
+		conn, err := net.ListenPacket("udp", lconf.Listen)
+		if err != nil {
+			fault("Could not listen on udp %s: %s", lconf.Listen, err)
+		}
+		emit("syslog listener listening on udp %s\n", lconf.Listen)
+		serveSyslogPackets(conn, lconf, output)
+		return
+	}
+
+	ln, err := lconf.listen()
+	if err != nil {
+		fault("Could not listen on %s %s: %s", lconf.Protocol, lconf.Listen, err)
+	}
+	emit("syslog listener listening on %s %s\n", lconf.Protocol, lconf.Listen)
+	serveSyslogStream(ln, lconf, output)
+}
+
+// udpSender is the channel of the messages of a udp sender
+type udpSender struct {
+	messages chan string
+	last     time.Time // last message received
+}
+
+func serveSyslogPackets(conn net.PacketConn, lconf *ListenerConfig, output chan *FileEvent) {
+	defer conn.Close()
+
+	// each sender has its own multiline buffer
+	senders := make(map[string]*udpSender)
+	defer func() {
+		for _, sender := range senders {
+			close(sender.messages)
+		}
+	}()
+	idle, max := syslogSenderIdle, syslogMaxSenders
+	buffer := make([]byte, 64<<10)
+	lastEvict := time.Now()
+	for {
+		// wake up to forget the idle senders even if nothing is received
+		conn.SetReadDeadline(time.Now().Add(idle))
+		n, addr, err := conn.ReadFrom(buffer)
+		if now := time.Now(); now.Sub(lastEvict) >= idle/2 {
+			evictSyslogSenders(senders, now.Add(-idle))
+			lastEvict = now
+		}
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			emit("syslog listener: read failed: %s\n", err)
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+
+		sender, ok := senders[addr.String()]
+		if !ok {
+			if len(senders) >= max {
+				evictSyslogSenders(senders, time.Time{})
+			}
+			sender = &udpSender{messages: make(chan string, 16)}
+			senders[addr.String()] = sender
+			go newSyslogSender(lconf, "udp", addr).run(sender.messages, output)
+		}
+		sender.last = time.Now()
+		sender.messages <- strings.TrimRight(string(buffer[:n]), "\r\n")
+	}
+}
+
+// evictSyslogSenders closes the senders without messages since before, their
+// multiline events are flushed. if before is zero the oldest one is closed.
+func evictSyslogSenders(senders map[string]*udpSender, before time.Time) {
+	if before.IsZero() {
+		var oldest string
+		for addr, sender := range senders {
+			if oldest == "" || sender.last.Before(senders[oldest].last) {
+				oldest = addr
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		before = senders[oldest].last.Add(time.Nanosecond)
+	}
+	for addr, sender := range senders {
+		if sender.last.Before(before) {
+			close(sender.messages)
+			delete(senders, addr)
+		}
+	}
+}
+
+func serveSyslogStream(ln net.Listener, lconf *ListenerConfig, output chan *FileEvent) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			emit("syslog listener: accept failed: %s\n", err)
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(time.Second)
+				continue
+			}
+			return
+		}
+
+		messages := make(chan string, 16)
+		go readSyslogStream(conn, lconf, messages)
+		go newSyslogSender(lconf, lconf.Protocol, conn.RemoteAddr()).run(messages, output)
+	}
+}
+
+// readSyslogStream reads the frames of conn into messages until it is closed
+func readSyslogStream(conn net.Conn, lconf *ListenerConfig, messages chan string) {
+	defer close(messages)
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		text, err := readSyslogFrame(reader, lconf.Framing, lconf.MaxBytes)
+		if err != nil {
+			if err != io.EOF {
+				emit("syslog listener: %s: %s\n", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		messages <- text
+	}
+}
+
+// readSyslogFrame reads a message framed by newline or octet-counting,
+// RFC 6587. messages longer than maxBytes are truncated, the rest is read
+// and dropped without being buffered.
+func readSyslogFrame(reader *bufio.Reader, framing string, maxBytes int) (string, error) {
+	if framing != "octet-counting" {
+		var text []byte
+		for {
+			segment, err := reader.ReadSlice('\n')
+			if maxBytes > 0 && len(text)+len(segment) > maxBytes {
+				segment = segment[:maxBytes-len(text)]
+			}
+			text = append(text, segment...)
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err != nil && (err != io.EOF || len(text) == 0) {
+				return "", err
+			}
+			return strings.TrimRight(string(text), "\r\n"), nil
+		}
+	}
+
+	length, err := reader.ReadString(' ')
+	if err != nil {
+		if err == io.EOF && length != "" {
+			return "", errSyslogFrame
+		}
+		return "", err
+	}
+	length = strings.TrimLeft(length[:len(length)-1], "\r\n")
+	if len(length) > syslogMaxFrameDigits {
+		return "", errSyslogFrame
+	}
+	n, err := strconv.ParseInt(length, 10, 64)
+	if err != nil || n < 1 {
+		return "", errSyslogFrame
+	}
+
+	keep := n
+	if maxBytes > 0 && keep > int64(maxBytes) {
+		keep = int64(maxBytes)
+	}
+	text := make([]byte, keep)
+	if _, err = io.ReadFull(reader, text); err != nil {
+		return "", err
+	}
+	if _, err = io.CopyN(ioutil.Discard, reader, n-keep); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(text), "\r\n"), nil
+}
+
+// syslogSender turns the messages of one sender into events
+type syslogSender struct {
+	lconf     *ListenerConfig
+	source    string
+	hostname  string
+	line      uint64
+	multiline *multilineBuffer
+}
+
+func newSyslogSender(lconf *ListenerConfig, protocol string, addr net.Addr) *syslogSender {
+	hostname := addr.String()
+	if host, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = host
+	}
+	s := &syslogSender{
+		lconf:    lconf,
+		source:   protocol + "://" + addr.String(),
+		hostname: hostname,
+	}
+	if lconf.Multiline != nil {
+		s.multiline = &multilineBuffer{conf: lconf.Multiline}
+	}
+	return s
+}
+
+// run sends the messages as events until messages is closed. the multiline
+// buffer is flushed after Multiline.Timeout without messages.
+func (s *syslogSender) run(messages <-chan string, output chan *FileEvent) {
+	var timeout <-chan time.Time
+	for {
+		select {
+		case text, ok := <-messages:
+			if !ok {
+				s.flush(output)
+				return
+			}
+			s.message(text, output)
+			if s.multiline != nil && s.lconf.Multiline.Timeout > 0 {
+				timeout = time.After(s.lconf.Multiline.Timeout)
+			}
+		case <-timeout:
+			s.flush(output)
+			timeout = nil
+		}
+	}
+}
+
+func (s *syslogSender) message(text string, output chan *FileEvent) {
+	if s.multiline == nil {
+		s.send(text, output)
+		return
+	}
+	if merged, _, ok := s.multiline.add(text, 0); ok {
+		s.send(merged, output)
+	}
+}
+
+func (s *syslogSender) flush(output chan *FileEvent) {
+	if s.multiline == nil {
+		return
+	}
+	if merged, _, ok := s.multiline.flush(); ok {
+		s.send(merged, output)
+	}
+}
+
+func (s *syslogSender) send(text string, output chan *FileEvent) {
+	if !s.lconf.keepLine(text) {
+		return
+	}
+	s.line++
+	event := s.lconf.newEvent(&s.source, &text, 0, s.line)
+	event.Hostname = &s.hostname
+	output <- event
+}
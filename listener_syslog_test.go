@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestListener(protocol string, framing string) *ListenerConfig {
+	lconf := &ListenerConfig{Protocol: protocol, Listen: "127.0.0.1:0", Framing: framing}
+	lconf.Codec = "syslog"
+	lconf.Fields = map[string]string{"type": "syslog"}
+	lconf.MaxBytes = 1024
+	lconf.NoTimestamp = true
+	return lconf
+}
+
+func receiveEvent(t *testing.T, events chan *FileEvent) *FileEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for an event")
+	}
+	return nil
+}
+
+func TestSyslogListenerTCP(t *testing.T) {
+	for _, framing := range []string{"newline", "octet-counting"} {
+		lconf := newTestListener("tcp", framing)
+		chkerr(t, lconf.check())
+		ln, err := lconf.listen()
+		chkerr(t, err)
+
+		events := make(chan *FileEvent, 16)
+		go serveSyslogStream(ln, lconf, events)
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		chkerr(t, err)
+		messages := []string{"<13>Oct  1 22:14:15 router1 sshd[42]: accepted", "<14>1 2003-10-11T22:14:15Z router2 app - - - hello\nworld"}
+		for _, message := range messages {
+			if framing == "newline" {
+				fmt.Fprintf(conn, "%s\n", strings.Replace(message, "\n", " ", -1))
+			} else {
+				fmt.Fprintf(conn, "%d %s", len(message), message)
+			}
+		}
+		conn.Close()
+
+		event := receiveEvent(t, events)
+		decoded := decodeJsonFormat(t, event)
+		if decoded["appname"] != "sshd" || decoded["hostname"] != "router1" || decoded["type"] != "syslog" || decoded["host"] != "127.0.0.1" {
+			t.Errorf("%s: unexpected fields: %v", framing, decoded)
+		}
+		if !strings.HasPrefix(*event.Source, "tcp://127.0.0.1:") {
+			t.Errorf("%s: unexpected source %s", framing, *event.Source)
+		}
+
+		decoded = decodeJsonFormat(t, receiveEvent(t, events))
+		expected := "hello world"
+		if framing == "octet-counting" {
+			expected = "hello\nworld"
+		}
+		if decoded["message"] != expected {
+			t.Errorf("%s: expected message %q, got %v", framing, expected, decoded)
+		}
+		ln.Close()
+	}
+}
+
+func TestSyslogListenerUDPMultiline(t *testing.T) {
+	lconf := newTestListener("udp", "")
+	lconf.Codec = "plain"
+	lconf.Multiline = &MultilineConfig{MatchRegexp: regexp.MustCompile(`^\s`), What: "follower", MaxLine: 10, Timeout: 50 * time.Millisecond}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	chkerr(t, err)
+	defer conn.Close()
+	events := make(chan *FileEvent, 16)
+	go serveSyslogPackets(conn, lconf, events)
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	chkerr(t, err)
+	defer client.Close()
+	for _, message := range []string{"panic: oops\n", "  at main.go:1", "  at main.go:2", "next"} {
+		fmt.Fprint(client, message)
+	}
+
+	if event := receiveEvent(t, events); *event.Text != "panic: oops\n  at main.go:1\n  at main.go:2" {
+		t.Errorf("Unexpected multiline event %q", *event.Text)
+	}
+	// flushed after the timeout
+	if event := receiveEvent(t, events); *event.Text != "next" {
+		t.Errorf("Unexpected event %q", *event.Text)
+	}
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("11 hello world5 abcde"))
+	text, err := readSyslogFrame(reader, "octet-counting", 5)
+	chkerr(t, err)
+	if text != "hello" {
+		t.Errorf("Expected the frame truncated to 5 bytes, got %q", text)
+	}
+	if text, _ = readSyslogFrame(reader, "octet-counting", 5); text != "abcde" {
+		t.Errorf("Expected the next frame, got %q", text)
+	}
+
+	reader = bufio.NewReader(strings.NewReader("abc hello"))
+	if _, err = readSyslogFrame(reader, "octet-counting", 0); err != errSyslogFrame {
+		t.Errorf("Expected errSyslogFrame, got %v", err)
+	}
+}
+
+func TestSyslogListenerUDPEvict(t *testing.T) {
+	defer func(idle time.Duration, max int) { syslogSenderIdle, syslogMaxSenders = idle, max }(syslogSenderIdle, syslogMaxSenders)
+	syslogSenderIdle, syslogMaxSenders = 100*time.Millisecond, 1
+
+	// the multiline events are only flushed when their sender is forgotten
+	lconf := newTestListener("udp", "")
+	lconf.Codec = "plain"
+	lconf.Multiline = &MultilineConfig{MatchRegexp: regexp.MustCompile(`^\s`), MaxLine: 10}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	chkerr(t, err)
+	defer conn.Close()
+	events := make(chan *FileEvent, 16)
+	go serveSyslogPackets(conn, lconf, events)
+
+	first, err := net.Dial("udp", conn.LocalAddr().String())
+	chkerr(t, err)
+	defer first.Close()
+	fmt.Fprint(first, "first")
+	// idle
+	if event := receiveEvent(t, events); *event.Text != "first" {
+		t.Errorf("Unexpected event %q", *event.Text)
+	}
+
+	fmt.Fprint(first, "again")
+	time.Sleep(20 * time.Millisecond)
+	second, err := net.Dial("udp", conn.LocalAddr().String())
+	chkerr(t, err)
+	defer second.Close()
+	fmt.Fprint(second, "second")
+	// more than syslogMaxSenders
+	if event := receiveEvent(t, events); *event.Text != "again" || !strings.HasSuffix(*event.Source, first.LocalAddr().String()) {
+		t.Errorf("Unexpected event %q from %s", *event.Text, *event.Source)
+	}
+}
+
+func TestReadSyslogFrameNewline(t *testing.T) {
+	// the rest of the long line is dropped as it is read
+	reader := bufio.NewReaderSize(strings.NewReader("hello world, a line longer than the buffer\r\nnext\r\nlast"), 16)
+	for _, expected := range []string{"hello", "next", "last"} {
+		text, err := readSyslogFrame(reader, "newline", 5)
+		chkerr(t, err)
+		if text != expected {
+			t.Errorf("Expected %q, got %q", expected, text)
+		}
+	}
+	if _, err := readSyslogFrame(reader, "newline", 5); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+}
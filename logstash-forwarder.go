@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -22,12 +21,15 @@ var exitStat = struct {
 
 var options = &struct {
 	configArg           string
+	registryFile        string
+	registryNamespace   string
 	spoolSize           uint64
 	harvesterBufferSize int
 	cpuProfileFile      string
 	idleTimeout         time.Duration
 	useSyslog           bool
 	tailOnRotate        bool
+	poll                bool
 	quiet               bool
 	pprof               bool
 	pprofAddr           string
@@ -41,11 +43,14 @@ var options = &struct {
 func emitOptions() {
 	emit("\t--- options -------\n")
 	emit("\tconfig-arg:          %s\n", options.configArg)
+	emit("\tregistry-file:       %s\n", options.registryFile)
+	emit("\tregistry-namespace:  %s\n", options.registryNamespace)
 	emit("\tidle-timeout:        %v\n", options.idleTimeout)
 	emit("\tspool-size:          %d\n", options.spoolSize)
 	emit("\tharvester-buff-size: %d\n", options.harvesterBufferSize)
 	emit("\t--- flags ---------\n")
 	emit("\ttail (on-rotation):  %t\n", options.tailOnRotate)
+	emit("\tpoll:                %t\n", options.poll)
 	emit("\tlog-to-syslog:          %t\n", options.useSyslog)
 	emit("\tquiet:             %t\n", options.quiet)
 	emit("\tpprof:             %t\n", options.pprof)
@@ -69,6 +74,9 @@ const logflags = log.Ldate | log.Ltime | log.Lmicroseconds
 func init() {
 	flag.StringVar(&options.configArg, "config", options.configArg, "path to logstash-forwarder configuration file or directory")
 
+	flag.StringVar(&options.registryFile, "registry-file", options.registryFile, "path to the registry of the file positions, overrides registry_file of the config")
+	flag.StringVar(&options.registryNamespace, "registry-namespace", options.registryNamespace, "namespace of the registry, overrides registry_namespace of the config")
+
 	flag.StringVar(&options.cpuProfileFile, "cpuprofile", options.cpuProfileFile, "path to cpu profile output - note: exits on profile end.")
 
 	flag.Uint64Var(&options.spoolSize, "spool-size", options.spoolSize, "event count spool threshold - forces network flush")
@@ -83,6 +91,8 @@ func init() {
 	flag.BoolVar(&options.tailOnRotate, "tail", options.tailOnRotate, "always tail on log rotation -note: may skip entries ")
 	flag.BoolVar(&options.tailOnRotate, "t", options.tailOnRotate, "always tail on log rotation -note: may skip entries ")
 
+	flag.BoolVar(&options.poll, "poll", options.poll, "poll files for changes instead of watching them with inotify")
+
 	flag.BoolVar(&options.quiet, "quiet", options.quiet, "operate in quiet mode - only emit errors to log")
 	flag.BoolVar(&options.pprof, "pprof", false, "if pprof")
 	flag.StringVar(&options.pprofAddr, "pprof-address", "127.0.0.1:8899", "default: 127.0.0.1:8899")
@@ -160,7 +170,7 @@ func main() {
 	publisher_chan := make(chan []*FileEvent, 1)
 	registrar_chan := make(chan []*FileEvent, 1)
 
-	if len(config.Files) == 0 {
+	if len(config.Files) == 0 && len(config.Listeners) == 0 && len(config.Journald) == 0 && config.Receiver.Listen == "" {
 		log.Fatalf("No paths given. What files do you want me to watch?\n")
 	}
 
@@ -168,6 +178,7 @@ func main() {
 	// - prospector: finds files in paths/globs to harvest, starts harvesters
 	// - harvester: reads a file, sends events to the spooler
 	// - spooler: buffers events until ready to flush to the publisher
+	// - disk queue (optional): stores batches on disk before publishing them
 	// - publisher: writes to the network, notifies registrar
 	// - registrar: records positions of files read
 	// Finally, prospector uses the registrar information, on restart, to
@@ -177,19 +188,51 @@ func main() {
 	restart.persist = make(chan *FileState)
 
 	// Load the previous log file locations now, for use in prospector
-	restart.files = make(map[string]*FileState)
-	if existing, e := os.Open(".logstash-forwarder"); e == nil {
-		defer existing.Close()
-		wd := ""
-		if wd, e = os.Getwd(); e != nil {
-			emit("WARNING: os.Getwd retuned unexpected error %s -- ignoring\n", e.Error())
-		}
-		emit("Loading registrar data from %s/.logstash-forwarder\n", wd)
+	registry, err := registryPath(&config)
+	if err != nil {
+		fault("Invalid registry: %s", err)
+	}
+	// Only one instance may use a registry
+	lock, err := lockRegistry(registry)
+	if err != nil {
+		fault("Could not lock the registry: %s", err)
+	}
+	defer lock.Close()
+	emit("Loading registrar data from %s\n", registry)
+	restart.files = loadRegistry(registry)
+
+	persist := startInputs(&config, restart, event_chan)
 
-		decoder := json.NewDecoder(existing)
-		decoder.Decode(&restart.files)
+	// Harvesters dump events into the spooler.
+	go Spool(event_chan, publisher_chan, options.spoolSize, options.idleTimeout)
+
+	publisher, err := NewPublisher(&config)
+	if err != nil {
+		fault("Could not create publisher: %s", err)
 	}
+	defer publisher.Close()
 
+	// with a disk queue, batches are handed to the registrar once they are on
+	// disk, and published from there.
+	if config.DiskQueue.Path != "" {
+		queue, err := NewDiskQueue(&config.DiskQueue, config.fileConfigs())
+		if err != nil {
+			fault("Could not open disk queue %s: %s", config.DiskQueue.Path, err)
+		}
+		go queue.Run(publisher_chan, registrar_chan, publisher)
+		defer queue.Close()
+	} else {
+		go Publish(publisher_chan, registrar_chan, publisher)
+	}
+
+	// registrar records last acknowledged positions in all files.
+	Registrar(persist, registry, &config.RegistryCleanup, registrar_chan)
+}
+
+// startInputs launches the prospectors and waits for them to initialise, and
+// then starts the receiver, the syslog listeners and the journald inputs. it
+// returns the states the registrar has to persist.
+func startInputs(config *Config, restart *ProspectorResume, event_chan chan *FileEvent) map[string]*FileState {
 	pendingProspectorCnt := 0
 
 	// Prospect the globs/paths given on the command line and launch harvesters
@@ -204,29 +247,50 @@ func main() {
 	emit("Waiting for %d prospectors to initialise\n", pendingProspectorCnt)
 	persist := make(map[string]*FileState)
 
-	for event := range restart.persist {
-		if event.Source == nil {
-			pendingProspectorCnt--
-			if pendingProspectorCnt == 0 {
-				break
+	// nothing is sent on persist without prospectors, the receiver, the
+	// listeners or journald could be the only inputs
+	if pendingProspectorCnt > 0 {
+		for event := range restart.persist {
+			if event.Source == nil {
+				pendingProspectorCnt--
+				if pendingProspectorCnt == 0 {
+					break
+				}
+				continue
 			}
-			continue
+			persist[*event.Source] = event
+			emit("Registrar will re-save state for %s\n", *event.Source)
 		}
-		persist[*event.Source] = event
-		emit("Registrar will re-save state for %s\n", *event.Source)
+	}
+
+	// journald inputs resume from their cursors instead
+	for source, state := range journaldStates(config, restart.files) {
+		persist[source] = state
+		emit("Registrar will re-save state for %s\n", source)
 	}
 
 	emit("All prospectors initialised with %d states to persist\n", len(persist))
 
-	// Harvesters dump events into the spooler.
-	go Spool(event_chan, publisher_chan, options.spoolSize, options.idleTimeout)
+	// Events received from other forwarders go to the spooler too
+	if config.Receiver.Listen != "" {
+		go ReceiveLumberjack(&config.Receiver, event_chan)
+	}
 
-	// go Publishv1(publisher_chan, registrar_chan, &config.Network)
-	go PublishKafka(publisher_chan, registrar_chan, &config.Kafka)
-	defer CloseProducer()
+	// and so do the messages of the syslog listeners
+	for idx := range config.Listeners {
+		go ListenSyslog(&config.Listeners[idx], event_chan)
+	}
 
-	// registrar records last acknowledged positions in all files.
-	Registrar(persist, registrar_chan)
+	for idx := range config.Journald {
+		jconf := &config.Journald[idx]
+		cursor := ""
+		if state, ok := persist[jconf.source()]; ok {
+			cursor = state.Cursor
+		}
+		go ReadJournald(jconf, cursor, event_chan)
+	}
+
+	return persist
 }
 
 // REVU: yes, this is a temp hack.
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// frames of the lumberjack v1 protocol, see PROTOCOL.md
+const (
+	lumberjackVersion         byte = '1'
+	lumberjackFrameData       byte = 'D'
+	lumberjackFrameAck        byte = 'A'
+	lumberjackFrameWindow     byte = 'W'
+	lumberjackFrameCompressed byte = 'C'
+
+	// refuse to allocate more than this for a single key, value or compressed payload
+	lumberjackMaxPayload = 64 << 20
+)
+
+type lumberjackFrame struct {
+	Type byte
+
+	// sequence number of data and ack frames
+	Sequence uint32
+	// window size of window frames
+	Window uint32
+	// key/value pairs of data frames
+	Data map[string]string
+	// uncompressed frame stream of compressed frames
+	Payload []byte
+}
+
+func writeUint32(w io.Writer, v uint32) error {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	_, err := w.Write(b[:])
+	return err
+}
+
+func writeFrameHeader(w io.Writer, frameType byte) error {
+	_, err := w.Write([]byte{lumberjackVersion, frameType})
+	return err
+}
+
+func writeDataFrame(w io.Writer, sequence uint32, data map[string]string) error {
+	if err := writeFrameHeader(w, lumberjackFrameData); err != nil {
+		return err
+	}
+	if err := writeUint32(w, sequence); err != nil {
+		return err
+	}
+	if err := writeUint32(w, uint32(len(data))); err != nil {
+		return err
+	}
+	for k, v := range data {
+		if err := writeUint32(w, uint32(len(k))); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, k); err != nil {
+			return err
+		}
+		if err := writeUint32(w, uint32(len(v))); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeWindowFrame(w io.Writer, size uint32) error {
+	if err := writeFrameHeader(w, lumberjackFrameWindow); err != nil {
+		return err
+	}
+	return writeUint32(w, size)
+}
+
+func writeAckFrame(w io.Writer, sequence uint32) error {
+	if err := writeFrameHeader(w, lumberjackFrameAck); err != nil {
+		return err
+	}
+	return writeUint32(w, sequence)
+}
+
+// writeCompressedFrame zlib compresses payload, which must be a stream of full frames
+func writeCompressedFrame(w io.Writer, payload []byte, level int) error {
+	var buffer bytes.Buffer
+	compressor, err := zlib.NewWriterLevel(&buffer, level)
+	if err != nil {
+		return err
+	}
+	compressor.Write(payload)
+	compressor.Close()
+
+	if err := writeFrameHeader(w, lumberjackFrameCompressed); err != nil {
+		return err
+	}
+	if err := writeUint32(w, uint32(buffer.Len())); err != nil {
+		return err
+	}
+	_, err = w.Write(buffer.Bytes())
+	return err
+}
+
+func readUint32(r io.Reader) (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
+
+func readBytes(r io.Reader) ([]byte, error) {
+	length, err := readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > lumberjackMaxPayload {
+		return nil, fmt.Errorf("lumberjack: payload of %d bytes is too large", length)
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readFrame reads one frame from r. the payload of a compressed frame is
+// returned uncompressed, it should be read again with readFrame.
+func readFrame(r io.Reader) (*lumberjackFrame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != lumberjackVersion {
+		return nil, fmt.Errorf("lumberjack: unsupported protocol version %q", header[0])
+	}
+
+	frame := &lumberjackFrame{Type: header[1]}
+	var err error
+	switch frame.Type {
+	case lumberjackFrameAck:
+		frame.Sequence, err = readUint32(r)
+	case lumberjackFrameWindow:
+		frame.Window, err = readUint32(r)
+	case lumberjackFrameData:
+		if frame.Sequence, err = readUint32(r); err != nil {
+			return nil, err
+		}
+		var count uint32
+		if count, err = readUint32(r); err != nil {
+			return nil, err
+		}
+		frame.Data = make(map[string]string)
+		for i := uint32(0); i < count; i++ {
+			var k, v []byte
+			if k, err = readBytes(r); err != nil {
+				return nil, err
+			}
+			if v, err = readBytes(r); err != nil {
+				return nil, err
+			}
+			frame.Data[string(k)] = string(v)
+		}
+	case lumberjackFrameCompressed:
+		var compressed []byte
+		if compressed, err = readBytes(r); err != nil {
+			return nil, err
+		}
+		var decompressor io.ReadCloser
+		if decompressor, err = zlib.NewReader(bytes.NewReader(compressed)); err != nil {
+			return nil, err
+		}
+		defer decompressor.Close()
+		frame.Payload, err = ioutil.ReadAll(io.LimitReader(decompressor, lumberjackMaxPayload))
+	default:
+		return nil, fmt.Errorf("lumberjack: unknown frame type %q", frame.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
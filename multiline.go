@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+)
+
+// multilineBuffer joins lines into events with the Multiline settings, for
+// the harvester and for the inputs which are not files
+type multilineBuffer struct {
+	conf  *MultilineConfig
+	lines []string
+	size  int // bytes read for the lines, with the ends of line
+}
+
+// add adds a line of size bytes, and returns the event it completed and its
+// size if there is one
+func (m *multilineBuffer) add(text string, size int) (string, int, bool) {
+	match := m.conf.MatchRegexp.MatchString(text)
+	if m.conf.Invert {
+		match = !match
+	}
+
+	// a leader, or a line which is not a follower, starts a new event
+	if match == m.conf.Leader {
+		merged, mergedSize, ok := m.flush()
+		m.lines = append(m.lines, text)
+		m.size = size
+		return merged, mergedSize, ok
+	}
+
+	m.lines = append(m.lines, text)
+	m.size += size
+	if m.conf.MaxLine > 0 && len(m.lines) >= m.conf.MaxLine {
+		return m.flush()
+	}
+	return "", 0, false
+}
+
+// skip counts size bytes read which are not a line of the event, it returns
+// false if no event is being joined
+func (m *multilineBuffer) skip(size int) bool {
+	if len(m.lines) == 0 {
+		return false
+	}
+	m.size += size
+	return true
+}
+
+// flush returns the event being joined and its size, if there is one
+func (m *multilineBuffer) flush() (string, int, bool) {
+	if len(m.lines) == 0 {
+		return "", 0, false
+	}
+	merged, size := strings.Join(m.lines, "\n"), m.size
+	m.lines, m.size = nil, 0
+	return merged, size, true
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestMultilineBuffer(t *testing.T) {
+	// lines not starting with a date continue the previous one
+	m := &multilineBuffer{conf: &MultilineConfig{MatchRegexp: regexp.MustCompile(`^\d{4}-`), Invert: true, MaxLine: 3}}
+
+	if _, _, ok := m.add("2020-01-01 first", 17); ok {
+		t.Fatalf("Expected the first line to be held")
+	}
+	m.add("  at a", 7)
+	if !m.skip(4) {
+		t.Fatalf("Expected the skipped bytes to be counted in the event")
+	}
+	if text, size, ok := m.add("2020-01-02 second", 18); !ok || text != "2020-01-01 first\n  at a" || size != 28 {
+		t.Fatalf("Unexpected event %q of %d bytes", text, size)
+	}
+
+	// at most MaxLine lines
+	m.add("  at b", 7)
+	if text, size, ok := m.add("  at c", 7); !ok || text != "2020-01-02 second\n  at b\n  at c" || size != 32 {
+		t.Fatalf("Unexpected event %q of %d bytes", text, size)
+	}
+	if _, _, ok := m.flush(); ok || m.skip(1) {
+		t.Fatalf("Expected no event left")
+	}
+}
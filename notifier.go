@@ -0,0 +1,182 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dirEvent is a change in a watched directory, name is the file changed in
+// it. name is empty if the directory itself is gone, and dir too if events
+// were lost.
+type dirEvent struct {
+	dir     string
+	name    string
+	created bool // created, removed or renamed, not only written
+}
+
+// dirWatcher watches directories for changes of their files, with inotify on
+// linux. newDirWatcher returns an error where it is not supported.
+type dirWatcher interface {
+	watch(dir string) error
+}
+
+// fileNotifier wakes up the prospectors and harvesters waiting for their
+// files to change, so they do not have to poll them. prospectors are woken up
+// when files are created, removed or renamed in their directories, harvesters
+// when their file is written too.
+type fileNotifier struct {
+	mutex       sync.Mutex
+	watcher     dirWatcher
+	watched     map[string]bool
+	subscribers map[string]map[chan struct{}]bool
+}
+
+var notifier struct {
+	sync.Once
+	*fileNotifier
+}
+
+// getNotifier returns the notifier, or nil if files are polled
+func getNotifier() *fileNotifier {
+	notifier.Do(func() {
+		if options.poll {
+			return
+		}
+		events := make(chan dirEvent, 64)
+		watcher, err := newDirWatcher(events)
+		if err != nil {
+			emit("Could not watch files, polling them instead: %s\n", err)
+			return
+		}
+		notifier.fileNotifier = newFileNotifier(watcher, events)
+	})
+	return notifier.fileNotifier
+}
+
+func newFileNotifier(watcher dirWatcher, events chan dirEvent) *fileNotifier {
+	n := &fileNotifier{
+		watcher:     watcher,
+		watched:     make(map[string]bool),
+		subscribers: make(map[string]map[chan struct{}]bool),
+	}
+	go n.run(events)
+	return n
+}
+
+func (n *fileNotifier) run(events chan dirEvent) {
+	for event := range events {
+		if event.dir == "" {
+			n.notifyAll()
+			continue
+		}
+		if event.name == "" {
+			// the directory itself is gone, it has to be watched again if it comes back
+			n.mutex.Lock()
+			delete(n.watched, event.dir)
+			n.mutex.Unlock()
+			n.notify(event.dir)
+			continue
+		}
+		if event.created {
+			n.notify(event.dir)
+		}
+		n.notify(filepath.Join(event.dir, event.name))
+	}
+}
+
+func (n *fileNotifier) notify(path string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for ch := range n.subscribers[path] {
+		// the channels have room for one wake up, more are not needed
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (n *fileNotifier) notifyAll() {
+	n.mutex.Lock()
+	paths := make([]string, 0, len(n.subscribers))
+	for path := range n.subscribers {
+		paths = append(paths, path)
+	}
+	n.mutex.Unlock()
+	for _, path := range paths {
+		n.notify(path)
+	}
+}
+
+// subscribe sends to ch when path changes. path is a directory, or a file
+// whose directory is watched. it returns false if path could not be watched.
+func (n *fileNotifier) subscribe(path string, dir bool, ch chan struct{}) bool {
+	if n == nil {
+		return false
+	}
+	path = filepath.Clean(path)
+	watchDir := path
+	if !dir {
+		watchDir = filepath.Dir(path)
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if !n.watched[watchDir] {
+		if err := n.watcher.watch(watchDir); err != nil {
+			return false
+		}
+		n.watched[watchDir] = true
+	}
+	if n.subscribers[path] == nil {
+		n.subscribers[path] = make(map[chan struct{}]bool)
+	}
+	n.subscribers[path][ch] = true
+	return true
+}
+
+func (n *fileNotifier) unsubscribe(path string, ch chan struct{}) {
+	if n == nil {
+		return
+	}
+	path = filepath.Clean(path)
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	delete(n.subscribers[path], ch)
+	if len(n.subscribers[path]) == 0 {
+		delete(n.subscribers, path)
+	}
+}
+
+// globDirs are the directories a glob could match files in, the existing
+// ones and the deepest one without wildcards, where new ones are created
+func globDirs(pattern string) []string {
+	if strings.Contains(pattern, "**") {
+		return globSubdirs(filepath.Clean(pattern))
+	}
+	dirs, _ := filepath.Glob(filepath.Dir(pattern))
+	if static := staticDir(pattern); static != filepath.Dir(pattern) {
+		dirs = append(dirs, static)
+	}
+	return dirs
+}
+
+// waitForChange waits until ch is notified or for timeout. it returns true if
+// it was notified. it only sleeps if ch is nil.
+func waitForChange(ch chan struct{}, timeout time.Duration) bool {
+	if ch == nil {
+		time.Sleep(timeout)
+		return false
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeWatcher struct {
+	dirs []string
+}
+
+func (w *fakeWatcher) watch(dir string) error {
+	w.dirs = append(w.dirs, dir)
+	return nil
+}
+
+func expectNotified(t *testing.T, ch chan struct{}, expected bool, what string) {
+	if notified := waitForChange(ch, 100*time.Millisecond); notified != expected {
+		t.Fatalf("Expected %s to be notified: %t, got %t", what, expected, notified)
+	}
+}
+
+func TestFileNotifierDispatch(t *testing.T) {
+	watcher := &fakeWatcher{}
+	events := make(chan dirEvent)
+	n := newFileNotifier(watcher, events)
+	defer close(events)
+
+	dir, file := make(chan struct{}, 1), make(chan struct{}, 1)
+	n.subscribe("/var/log/", true, dir)
+	n.subscribe("/var/log/app.log", false, file)
+	if len(watcher.dirs) != 1 || watcher.dirs[0] != "/var/log" {
+		t.Fatalf("Expected /var/log to be watched once, got %v", watcher.dirs)
+	}
+
+	// appends only wake up the harvester
+	events <- dirEvent{dir: "/var/log", name: "app.log"}
+	expectNotified(t, file, true, "the file")
+	expectNotified(t, dir, false, "the directory")
+
+	// rotations wake up both
+	events <- dirEvent{dir: "/var/log", name: "app.log", created: true}
+	expectNotified(t, file, true, "the file")
+	expectNotified(t, dir, true, "the directory")
+
+	events <- dirEvent{}
+	expectNotified(t, file, true, "the file after lost events")
+	expectNotified(t, dir, true, "the directory after lost events")
+
+	// the directory is watched again once it is gone
+	events <- dirEvent{dir: "/var/log"}
+	expectNotified(t, dir, true, "the removed directory")
+	n.unsubscribe("/var/log/app.log", file)
+	n.subscribe("/var/log", true, dir)
+	if len(watcher.dirs) != 2 {
+		t.Fatalf("Expected /var/log to be watched again, got %v", watcher.dirs)
+	}
+	events <- dirEvent{dir: "/var/log", name: "app.log"}
+	expectNotified(t, file, false, "the unsubscribed file")
+}
+
+func TestGlobDirs(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+	chkerr(t, os.MkdirAll(filepath.Join(tmpdir, "pods", "a", "web"), 0755))
+
+	dirs := globDirs(filepath.Join(tmpdir, "pods", "*", "web", "*.log"))
+	expected := []string{filepath.Join(tmpdir, "pods", "a", "web"), filepath.Join(tmpdir, "pods")}
+	if len(dirs) != len(expected) || dirs[0] != expected[0] || dirs[1] != expected[1] {
+		t.Fatalf("Expected %v, got %v", expected, dirs)
+	}
+}
+
+func TestDirWatcher(t *testing.T) {
+	events := make(chan dirEvent, 16)
+	watcher, err := newDirWatcher(events)
+	if err != nil {
+		t.Skipf("Files can not be watched here: %s", err)
+	}
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+	chkerr(t, watcher.watch(tmpdir))
+
+	path := filepath.Join(tmpdir, "app.log")
+	chkerr(t, ioutil.WriteFile(path, []byte("line\n"), 0644))
+	select {
+	case event := <-events:
+		if event.dir != tmpdir || event.name != "app.log" || !event.created {
+			t.Fatalf("Unexpected event %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected an event for the new file")
+	}
+}
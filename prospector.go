@@ -2,7 +2,6 @@ package main
 
 import (
 	"os"
-	"path/filepath"
 	"time"
 )
 
@@ -15,6 +14,9 @@ type ProspectorInfo struct {
 	fileinfo  os.FileInfo /* the file info */
 	harvester chan int64  /* the harvester will send an event with its offset when it closes */
 	last_seen uint32      /* int number of the last iterations in which we saw this file */
+
+	fingerprint     string /* hash of the start of the file, tells apart files reusing an inode */
+	fingerprintSize int64
 }
 
 type Prospector struct {
@@ -22,10 +24,12 @@ type Prospector struct {
 	prospectorinfo map[string]ProspectorInfo
 	iteration      uint32
 	lastscan       time.Time
+	notify         chan struct{} /* wakes up the prospector when files are created, removed or renamed */
 }
 
 func (p *Prospector) Prospect(resume *ProspectorResume, output chan *FileEvent) {
 	p.prospectorinfo = make(map[string]ProspectorInfo)
+	p.notify = make(chan struct{}, 1)
 
 	// Handle any "-" (stdin) paths
 	for i, path := range p.FileConfig.Paths {
@@ -63,8 +67,9 @@ func (p *Prospector) Prospect(resume *ProspectorResume, output chan *FileEvent)
 
 		p.lastscan = newlastscan
 
-		// Defer next scan for a bit.
-		time.Sleep(10 * time.Second) // Make this tunable
+		// Defer next scan for a bit, or until the directories of the globs change.
+		p.watch()
+		waitForChange(p.notify, 10*time.Second) // Make this tunable
 
 		// Clear out files that disappeared and we've stopped harvesting
 		for file, lastinfo := range p.prospectorinfo {
@@ -77,20 +82,37 @@ func (p *Prospector) Prospect(resume *ProspectorResume, output chan *FileEvent)
 	}
 } /* Prospect */
 
+// watch subscribes to the directories of the globs, new ones are found at each scan
+func (p *Prospector) watch() {
+	n := getNotifier()
+	if n == nil {
+		return
+	}
+	for _, path := range p.FileConfig.Paths {
+		for _, dir := range globDirs(path) {
+			n.subscribe(dir, true, p.notify)
+		}
+	}
+}
+
 func (p *Prospector) scan(path string, output chan *FileEvent, resume *ProspectorResume) {
 
-	// Evaluate the path as a wildcards/shell glob
-	matches, err := filepath.Glob(path)
+	// Evaluate the path as a wildcards/shell glob, ** included
+	matches, err := globFiles(path)
 	if err != nil {
 		emit("glob(%s) failed: %v\n", path, err)
 		return
 	}
 
 	// To keep the old inode/dev reference if we see a file has renamed, in case it was also renamed prior
-	missinginfo := make(map[string]os.FileInfo)
+	missinginfo := make(map[string]ProspectorInfo)
 
 	// Check any matched files to see if we need to start a harvester
 	for _, file := range matches {
+		if p.FileConfig.excludeFile(file) {
+			continue
+		}
+
 		// Stat the file, following any symlinks.
 		fileinfo, err := os.Stat(file)
 		// TODO(sissel): check err
@@ -114,10 +136,21 @@ func (p *Prospector) scan(path string, output chan *FileEvent, resume *Prospecto
 		if !is_known {
 			// Create a new prospector info with the stat info for comparison
 			newinfo = ProspectorInfo{fileinfo: fileinfo, harvester: make(chan int64, 1), last_seen: p.iteration}
+			newinfo.update_fingerprint(file, fileinfo)
 
 			// Check for dead time, but only if the file modification time is before the last scan started
 			// This ensures we don't skip genuine creations with dead times less than 10s
-			if fileinfo.ModTime().Before(p.lastscan) && time.Since(fileinfo.ModTime()) > p.FileConfig.deadtime {
+			if p.FileConfig.isArchive(file) {
+				if previous := is_file_renamed(file, fileinfo, p.prospectorinfo, missinginfo); previous != "" {
+					// The log rotation renamed the archive - it keeps the harvester and offset of its previous name
+					emit("Archive rename was detected: %s -> %s\n", previous, file)
+
+					newinfo.harvester = p.renamed_info(previous, missinginfo).harvester
+				} else {
+					// Archives are harvested once, whatever their age
+					p.scan_archive(file, fileinfo, newinfo, output, resume, missinginfo)
+				}
+			} else if fileinfo.ModTime().Before(p.lastscan) && time.Since(fileinfo.ModTime()) > p.FileConfig.deadtime {
 				var offset int64 = 0
 				var is_resuming bool = false
 
@@ -139,10 +172,10 @@ func (p *Prospector) scan(path string, output chan *FileEvent, resume *Prospecto
 					newinfo.harvester <- fileinfo.Size()
 				}
 			} else if previous := is_file_renamed(file, fileinfo, p.prospectorinfo, missinginfo); previous != "" {
-				// This file was simply renamed (known inode+dev) - link the same harvester channel as the old file
+				// This file was simply renamed (known inode+dev and start of file) - link the same harvester channel as the old file
 				emit("File rename was detected: %s -> %s\n", previous, file)
 
-				newinfo.harvester = p.prospectorinfo[previous].harvester
+				newinfo.harvester = p.renamed_info(previous, missinginfo).harvester
 			} else {
 				var offset int64 = 0
 				var is_resuming bool = false
@@ -180,7 +213,11 @@ func (p *Prospector) scan(path string, output chan *FileEvent, resume *Prospecto
 					emit("File rename was detected: %s -> %s\n", previous, file)
 					emit("Launching harvester on renamed file: %s\n", file)
 
-					newinfo.harvester = p.prospectorinfo[previous].harvester
+					newinfo.harvester = p.renamed_info(previous, missinginfo).harvester
+				} else if p.FileConfig.isArchive(file) {
+					// A new archive took the name of the previous one
+					newinfo.harvester = make(chan int64, 1)
+					p.scan_archive(file, fileinfo, newinfo, output, resume, missinginfo)
 				} else {
 					// File is not the same file we saw previously, it must have rotated and is a new file
 					emit("Launching harvester on rotated file: %s\n", file)
@@ -195,8 +232,12 @@ func (p *Prospector) scan(path string, output chan *FileEvent, resume *Prospecto
 
 				// Keep the old file in missinginfo so we don't rescan it if it was renamed and we've not yet reached the new filename
 				// We only need to keep it for the remainder of this iteration then we can assume it was deleted and forget about it
-				missinginfo[file] = lastinfo.fileinfo
-			} else if len(newinfo.harvester) != 0 && lastinfo.fileinfo.ModTime() != fileinfo.ModTime() {
+				missinginfo[file] = lastinfo
+
+				// The fingerprint is the one of the file now at the path
+				newinfo.fingerprint, newinfo.fingerprintSize = "", 0
+			} else if len(newinfo.harvester) != 0 && lastinfo.fileinfo.ModTime() != fileinfo.ModTime() && !p.FileConfig.isArchive(file) {
+				// archives are harvested once, even if they are touched
 				// Resume harvesting of an old file we've stopped harvesting from
 				emit("Resuming harvester on an old file that was just modified: %s\n", file)
 
@@ -209,21 +250,110 @@ func (p *Prospector) scan(path string, output chan *FileEvent, resume *Prospecto
 
 		// Track the stat data for this file for later comparison to check for
 		// rotation/etc
+		newinfo.update_fingerprint(file, fileinfo)
 		p.prospectorinfo[file] = newinfo
 	} // for each file matched by the glob
 }
 
+// renamed_info returns the info of previous, a file renamed in this scan. if a
+// new file took its name already, the info of previous is in missinginfo.
+func (p *Prospector) renamed_info(previous string, missinginfo map[string]ProspectorInfo) ProspectorInfo {
+	if info, ok := missinginfo[previous]; ok {
+		return info
+	}
+	return p.prospectorinfo[previous]
+}
+
+// scan_archive starts a harvester on an archive, unless the registrar recorded
+// it done. an archive of a file harvested, compressed by the log rotation,
+// is harvested from where the harvest of the file ended.
+func (p *Prospector) scan_archive(file string, fileinfo os.FileInfo, newinfo ProspectorInfo, output chan *FileEvent, resume *ProspectorResume, missinginfo map[string]ProspectorInfo) {
+	var last_state *FileState
+	if resume != nil {
+		last_state = p.resume_state(file, fileinfo, resume)
+	}
+
+	var offset int64 = 0
+	if last_state != nil && last_state.Done {
+		emit("Skipping archive already harvested: %s\n", file)
+		newinfo.harvester <- last_state.Offset
+		return
+	} else if last_state != nil {
+		emit("Resuming harvester on a previously harvested archive: %s\n", file)
+		offset = last_state.Offset
+	} else if head, err := archiveHead(file); err != nil {
+		emit("Failed decompressing %s: %s\n", file, err)
+	} else if source, info := p.archive_source_info(head, missinginfo); source != "" {
+		// the harvester of the file reads it to its end, the archive is harvested from there
+		emit("Archive %s is the compressed %s, waiting for its harvester\n", file, source)
+		go func() {
+			offset := <-info.harvester
+			info.harvester <- offset
+			emit("Launching harvester on archive %s from the end of %s\n", file, source)
+			harvester := &Harvester{Path: file, FileConfig: p.FileConfig, Offset: offset, FinishChan: newinfo.harvester}
+			harvester.Harvest(output)
+		}()
+		return
+	} else if source_state := p.archive_source_state(head, resume); source_state != nil {
+		emit("Resuming harvester on archive %s from the state of %s\n", file, *source_state.Source)
+		offset = source_state.Offset
+	} else {
+		emit("Launching harvester on archive: %s\n", file)
+	}
+
+	harvester := &Harvester{Path: file, FileConfig: p.FileConfig, Offset: offset, FinishChan: newinfo.harvester}
+	go harvester.Harvest(output)
+}
+
+// archive_source_info returns the file known by the prospector which head is
+// the start of, and its info
+func (p *Prospector) archive_source_info(head []byte, missinginfo map[string]ProspectorInfo) (string, ProspectorInfo) {
+	for _, known := range []map[string]ProspectorInfo{p.prospectorinfo, missinginfo} {
+		for kf, ki := range known {
+			if !p.FileConfig.isArchive(kf) && is_archive_of(head, ki.fingerprint, ki.fingerprintSize) {
+				return kf, ki
+			}
+		}
+	}
+	return "", ProspectorInfo{}
+}
+
+// archive_source_state returns the state in the registrar of the file which
+// head is the start of, or nil
+func (p *Prospector) archive_source_state(head []byte, resume *ProspectorResume) *FileState {
+	if resume == nil {
+		return nil
+	}
+	for source, state := range resume.files {
+		if state.Cursor == "" && !p.FileConfig.isArchive(source) && is_archive_of(head, state.Fingerprint, state.FingerprintSize) {
+			return state
+		}
+	}
+	return nil
+}
+
 func (p *Prospector) calculate_resume(file string, fileinfo os.FileInfo, resume *ProspectorResume) (int64, bool) {
+	if last_state := p.resume_state(file, fileinfo, resume); last_state != nil {
+		return last_state.Offset, true
+	}
+
+	// New file so just start from an automatic position
+	return 0, false
+}
+
+// resume_state returns the state of file in the registrar, or nil if it is a new file
+func (p *Prospector) resume_state(file string, fileinfo os.FileInfo, resume *ProspectorResume) *FileState {
 	last_state, is_found := resume.files[file]
 
-	if is_found && is_file_same(file, fileinfo, last_state) {
+	// The inode could have been reused by a new file, the start of the file tells them apart
+	if is_found && is_file_same(file, fileinfo, last_state) && is_fingerprint_same(file, last_state) {
 		// We're resuming - throw the last state back downstream so we resave it
-		// And return the offset - also force harvest in case the file is old and we're about to skip it
+		// And return it - also force harvest in case the file is old and we're about to skip it
 		resume.persist <- last_state
-		return last_state.Offset, true
+		return last_state
 	}
 
-	if previous := is_file_renamed_resumelist(file, fileinfo, resume.files); previous != "" {
+	if previous := is_file_renamed_resumelist(file, fileinfo, resume.files); previous != "" && is_fingerprint_same(file, resume.files[previous]) {
 		// File has rotated between shutdown and startup
 		// We return last state downstream, with a modified event source with the new file name
 		// And return the offset - also force harvest in case the file is old and we're about to skip it
@@ -231,13 +361,14 @@ func (p *Prospector) calculate_resume(file string, fileinfo os.FileInfo, resume
 		last_state := resume.files[previous]
 		last_state.Source = &file
 		resume.persist <- last_state
-		return last_state.Offset, true
+		return last_state
 	}
 
-	if is_found {
+	if is_found && is_file_same(file, fileinfo, last_state) {
+		emit("Not resuming file whose inode was reused: %s\n", file)
+	} else if is_found {
 		emit("Not resuming rotated file: %s\n", file)
 	}
 
-	// New file so just start from an automatic position
-	return 0, false
+	return nil
 }
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Publisher is implemented by every output backend.
+// Batches are acked in the same order they were published, and a batch is
+// only acked after the backend stored all of its events.
+type Publisher interface {
+	// Publish queues a batch, it blocks while the backend is busy
+	Publish(events []*FileEvent)
+	// Acked returns the channel acked batches are sent to
+	Acked() <-chan []*FileEvent
+	// Close stops the backend and releases its connections
+	Close() error
+}
+
+var publisherFactories = map[string]func(config *Config) (Publisher, error){
+	"kafka":      newKafkaPublisher,
+	"lumberjack": newLumberjackPublisher,
+}
+
+const defaultOutput = "kafka"
+
+// NewPublisher creates the backends listed in the "output" section of the
+// config. if more than one output is given, the batch is acked after all of
+// them acked it.
+func NewPublisher(config *Config) (Publisher, error) {
+	outputs := config.Output
+	if len(outputs) == 0 {
+		outputs = []string{defaultOutput}
+	}
+
+	seen := make(map[string]bool)
+	publishers := make([]Publisher, 0, len(outputs))
+	for _, output := range outputs {
+		if seen[output] {
+			continue
+		}
+		seen[output] = true
+
+		factory, ok := publisherFactories[output]
+		if !ok {
+			return nil, fmt.Errorf("unknown output: %q", output)
+		}
+		p, err := factory(config)
+		if err != nil {
+			return nil, fmt.Errorf("could not create output %q: %s", output, err)
+		}
+		publishers = append(publishers, p)
+	}
+
+	if len(publishers) == 1 {
+		return publishers[0], nil
+	}
+	return newMultiPublisher(publishers), nil
+}
+
+// Publish reads batches from the spooler and passes them to the publisher,
+// acked batches are handed to the registrar.
+func Publish(input chan []*FileEvent, registrar chan []*FileEvent, p Publisher) {
+	go func() {
+		for events := range p.Acked() {
+			registrar <- events
+		}
+	}()
+
+	for events := range input {
+		p.Publish(events)
+	}
+}
+
+// multiPublisher sends every batch to all of its publishers
+type multiPublisher struct {
+	publishers []Publisher
+	acked      chan []*FileEvent
+
+	mutex   sync.Mutex
+	batches [][]*FileEvent // published batches not acked by all the publishers yet
+}
+
+func newMultiPublisher(publishers []Publisher) *multiPublisher {
+	p := &multiPublisher{
+		publishers: publishers,
+		acked:      make(chan []*FileEvent, 1),
+	}
+	go p.run()
+	return p
+}
+
+func (p *multiPublisher) Publish(events []*FileEvent) {
+	p.mutex.Lock()
+	p.batches = append(p.batches, events)
+	p.mutex.Unlock()
+
+	for _, publisher := range p.publishers {
+		publisher.Publish(events)
+	}
+}
+
+func (p *multiPublisher) Acked() <-chan []*FileEvent {
+	return p.acked
+}
+
+func (p *multiPublisher) Close() (err error) {
+	for _, publisher := range p.publishers {
+		if e := publisher.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// run counts the acks of every batch, a batch is acked once all the publishers
+// acked it. the batches are numbered in the order they were published, every
+// publisher acks them in order so its n-th ack is the batch n. the acks of all
+// the publishers are read until they are closed, batches some of them did not
+// ack before closing are not acked.
+func (p *multiPublisher) run() {
+	defer close(p.acked)
+
+	acks := make(chan uint64)
+	var wg sync.WaitGroup
+	for _, publisher := range p.publishers {
+		wg.Add(1)
+		go func(publisher Publisher) {
+			defer wg.Done()
+			var id uint64
+			for range publisher.Acked() {
+				acks <- id
+				id++
+			}
+		}(publisher)
+	}
+	go func() {
+		wg.Wait()
+		close(acks)
+	}()
+
+	counts := make(map[uint64]int)
+	var next uint64 // id of the first batch not acked yet
+	for id := range acks {
+		counts[id]++
+		// a publisher acks the batch n only after the batch n-1, the batches
+		// are complete in order
+		for counts[next] == len(p.publishers) {
+			delete(counts, next)
+			next++
+
+			p.mutex.Lock()
+			events := p.batches[0]
+			p.batches = p.batches[1:]
+			p.mutex.Unlock()
+			p.acked <- events
+		}
+	}
+}
@@ -16,7 +16,7 @@ type KafkaConfig struct {
 	TopicIDTemplate  *template.Template
 	CompressionCodec string  `json:"compression_codec"`  // none, gzip or snappy
 	AckTimeoutMS     int     `json:"ack_timeout_ms"`     // milliseconds
-	RequiredAcks     string  `json:"required_acks"`      // no_response, wait_for_local, wait_for_all
+	RequiredAcks     string  `json:"required_acks"`      // no_response, wait_for_local (default), wait_for_all
 	FlushFrequencyMS int     `json:"flush_frequency_ms"` // milliseconds
 	WriteTimeout     string  `json:"write_timeout"`      // string, 100ms, 1s, default 1s
 	DailTimeout      string  `json:"dail_timeout"`       // string, 100ms, 1s, default 5s
@@ -24,6 +24,7 @@ type KafkaConfig struct {
 	RefreshFrequency int     `json:"refresh_frequency"`  // milliseconds
 	Key              *string `json:"key"`
 	KeyTemplate      *template.Template
+	RetryBackoff     string `json:"retry_backoff"` // string, 100ms, 1s, default 1s. wait before re-sending unacked messages
 }
 
 func MustParseInterval(interval string, dft time.Duration) time.Duration {
@@ -58,38 +59,41 @@ func newProducer(kconf *KafkaConfig) sarama.AsyncProducer {
 		config.Producer.Compression = sarama.CompressionNone
 	}
 
-	ra := strings.ToLower(kconf.RequiredAcks)
-	switch {
-	case ra == "no_response":
-		config.Producer.RequiredAcks = sarama.NoResponse
-	case ra == "wait_for_local":
-		config.Producer.RequiredAcks = sarama.WaitForLocal
-	case ra == "wait_for_all":
-		config.Producer.RequiredAcks = sarama.WaitForAll
-	default:
-		config.Producer.RequiredAcks = sarama.NoResponse
-	}
-
+	config.Producer.RequiredAcks = requiredAcks(kconf.RequiredAcks)
 	config.Producer.Timeout = time.Millisecond * time.Duration(kconf.AckTimeoutMS)
 	config.Producer.Flush.Frequency = time.Millisecond * time.Duration(kconf.FlushFrequencyMS)
 	config.Metadata.RefreshFrequency = time.Millisecond * time.Duration(kconf.RefreshFrequency)
 
+	// we need both of them to know when a batch could be handed to registrar
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+
 	producer, err := sarama.NewAsyncProducer(kconf.BrokerList, config)
 	if err != nil {
 		log.Println("failed to start producer:", err, kconf.BrokerList)
 		return nil
 	}
 
-	go func() {
-		for err := range producer.Errors() {
-			log.Println("produce error: ", err)
-		}
-	}()
-
 	log.Println("created new producer: ", kconf.BrokerList)
 	return producer
 }
 
+// requiredAcks returns the RequiredAcks of the required_acks config, the
+// leader has to store the messages by default
+func requiredAcks(ra string) sarama.RequiredAcks {
+	switch strings.ToLower(ra) {
+	case "no_response":
+		// the messages are successes as soon as they are sent, the registrar
+		// could save the offsets of events kafka never stored
+		log.Println("WARNING: required_acks is no_response, events not stored by kafka are lost")
+		return sarama.NoResponse
+	case "wait_for_all":
+		return sarama.WaitForAll
+	default:
+		return sarama.WaitForLocal
+	}
+}
+
 type iisLogEntry struct {
 	Line string
 
@@ -144,82 +148,171 @@ func (ile *iisLogKey) Encode() ([]byte, error) {
 	return ile.encoded, ile.err
 }
 
-var (
+// KafkaPublisher is the Publisher sending events to kafka, it is the default output
+type KafkaPublisher struct {
+	kconf    *KafkaConfig
 	producer sarama.AsyncProducer
-)
+	input    chan []*FileEvent
+	acked    chan []*FileEvent
+	done     chan struct{}
+	closing  chan struct{} // closed by Close, stops the retries of sendBatch
+}
 
-func get_producer(kconf *KafkaConfig) sarama.AsyncProducer {
-	if producer == nil {
-		producer = newProducer(kconf)
+func newKafkaPublisher(config *Config) (Publisher, error) {
+	p := &KafkaPublisher{
+		kconf:   &config.Kafka,
+		input:   make(chan []*FileEvent, 1),
+		acked:   make(chan []*FileEvent, 1),
+		done:    make(chan struct{}),
+		closing: make(chan struct{}),
 	}
-	return producer
+	go p.run()
+	return p, nil
 }
 
-func CloseProducer() {
-	if producer != nil {
-		producer.Close()
-	}
-}
-
-func PublishKafka(input chan []*FileEvent,
-	registrar chan []*FileEvent,
-	kconf *KafkaConfig) {
-
-	for events := range input {
-
-		p := get_producer(kconf)
-		if p == nil {
-			log.Println("no producer, events cnt: ", len(events))
-		} else {
-			for _, event := range events {
-				// skip too long text
-				if len(*event.Text) > event.MaxBytes {
-					continue
-				}
-				msg := JsonFormat(event)
-
-				entry := &iisLogEntry{
-					Line: string(msg),
-				}
-
-				buf := &bytes.Buffer{}
-				if err := kconf.TopicIDTemplate.Execute(buf, event.Fields); err != nil {
-					panic(err)
-				}
-				topic := buf.String()
-
-				if kconf.KeyTemplate == nil {
-					p.Input() <- &sarama.ProducerMessage{
-						Topic: topic,
-						Key:   nil,
-						Value: entry,
-					}
-				} else {
-					buf := &bytes.Buffer{}
-					if err := kconf.KeyTemplate.Execute(buf, event); err != nil {
-						panic(err)
-					}
-					key := &iisLogKey{
-						Line: buf.String(),
-					}
-
-					p.Input() <- &sarama.ProducerMessage{
-						Topic: topic,
-						Key:   key,
-						Value: entry,
-					}
-				}
+func (p *KafkaPublisher) get_producer() sarama.AsyncProducer {
+	if p.producer == nil {
+		p.producer = newProducer(p.kconf)
+	}
+	return p.producer
+}
+
+func (p *KafkaPublisher) Publish(events []*FileEvent) {
+	p.input <- events
+}
+
+func (p *KafkaPublisher) Acked() <-chan []*FileEvent {
+	return p.acked
+}
+
+func (p *KafkaPublisher) Close() error {
+	close(p.closing)
+	close(p.input)
+	<-p.done
+	if p.producer != nil {
+		return p.producer.Close()
+	}
+	return nil
+}
+
+func (p *KafkaPublisher) run() {
+	defer close(p.done)
+	defer close(p.acked)
+
+	for events := range p.input {
+		if !p.sendBatch(events) {
+			// closed before kafka acked the batch, it is sent again on restart
+			return
+		}
+		p.acked <- events
+	}
+}
+
+func newMessage(event *FileEvent, kconf *KafkaConfig) *sarama.ProducerMessage {
+	msg := JsonFormat(event)
+
+	entry := &iisLogEntry{
+		Line: string(msg),
+	}
+
+	buf := &bytes.Buffer{}
+	if err := kconf.TopicIDTemplate.Execute(buf, event.Fields); err != nil {
+		panic(err)
+	}
+	topic := buf.String()
+
+	if kconf.KeyTemplate == nil {
+		return &sarama.ProducerMessage{
+			Topic: topic,
+			Key:   nil,
+			Value: entry,
+		}
+	}
+
+	buf = &bytes.Buffer{}
+	if err := kconf.KeyTemplate.Execute(buf, event); err != nil {
+		panic(err)
+	}
+	key := &iisLogKey{
+		Line: buf.String(),
+	}
+
+	return &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   key,
+		Value: entry,
+	}
+}
+
+// produce pushes msgs into p and waits until every one of them is either acked
+// or failed. the failed messages are returned so that they could be re-sent.
+func produce(p sarama.AsyncProducer, msgs []*sarama.ProducerMessage) []*sarama.ProducerMessage {
+	// feed the input in another goroutine, Successes and Errors channels must be
+	// drained at the same time or the producer will block
+	go func() {
+		for _, msg := range msgs {
+			p.Input() <- msg
+		}
+	}()
+
+	var failed []*sarama.ProducerMessage
+	for acked := 0; acked < len(msgs); acked++ {
+		select {
+		case <-p.Successes():
+		case err := <-p.Errors():
+			log.Println("produce error: ", err)
+			failed = append(failed, err.Msg)
+		}
+	}
+	return failed
+}
+
+// sendBatch does not return until kafka acked all the events in the batch.
+// messages failed to be sent are re-sent after kconf.RetryBackoff, so does
+// the whole batch if the producer could not be created. it returns false if
+// the publisher was closed before the batch was acked.
+func (p *KafkaPublisher) sendBatch(events []*FileEvent) bool {
+	kconf := p.kconf
+	backoff := MustParseInterval(kconf.RetryBackoff, time.Second*1)
+
+	msgs := make([]*sarama.ProducerMessage, 0, len(events))
+	for _, event := range events {
+		// skip too long text, and the events only saved by the registrar
+		if event.StateOnly || len(*event.Text) > event.MaxBytes {
+			continue
+		}
+		msgs = append(msgs, newMessage(event, kconf))
+	}
+
+	for len(msgs) > 0 {
+		producer := p.get_producer()
+		if producer == nil {
+			log.Println("no producer, events cnt: ", len(msgs))
+			if !p.wait(backoff) {
+				return false
+			}
+			continue
+		}
+
+		msgs = produce(producer, msgs)
+		if len(msgs) > 0 {
+			log.Printf("%d messages unacked, retry in %v\n", len(msgs), backoff)
+			if !p.wait(backoff) {
+				return false
 			}
+		}
+	}
+	return true
+}
 
-			//FIXME: data may lost if remote kafka cluster down a little while. coz unacked events
-			// will not re-sent before the next ack. and ack only changes Offset of last success..
-			// if err == nil {
-			// 	// Tell the registrar that we've successfully sent these events
-			// 	registrar <- events
-			// } else {
-			// 	p = nil // if error happens, we nil producer, force it to reconnect
-			// }
-			registrar <- events
-		} // p == nil
-	} // for events := range input
+// wait sleeps for d, it returns false if the publisher was closed meanwhile
+func (p *KafkaPublisher) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-p.closing:
+		return false
+	case <-timer.C:
+		return true
+	}
 }
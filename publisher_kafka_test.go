@@ -2,8 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
 	"testing"
+	"text/template"
+	"time"
 )
 
 const (
@@ -27,7 +31,8 @@ func TestNewProducer(t *testing.T) {
 
 	t.Log(kconf, err)
 
-	p := newProducer(&kconf)
+	p := mocks.NewAsyncProducer(t, nil)
+	p.ExpectInputAndSucceed()
 	t.Log(p)
 
 	entry := &iisLogEntry{
@@ -44,3 +49,90 @@ func TestNewProducer(t *testing.T) {
 
 	// t.Error("...")
 }
+
+// newMockPublisher returns a KafkaPublisher sending to a mock producer
+func newMockPublisher(t *testing.T, retryBackoff string) (*KafkaPublisher, *mocks.AsyncProducer) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	producer := mocks.NewAsyncProducer(t, config)
+
+	p := &KafkaPublisher{
+		kconf: &KafkaConfig{
+			TopicIDTemplate: template.Must(template.New("topic").Parse("logs")),
+			RetryBackoff:    retryBackoff,
+		},
+		producer: producer,
+		input:    make(chan []*FileEvent, 1),
+		acked:    make(chan []*FileEvent, 1),
+		done:     make(chan struct{}),
+		closing:  make(chan struct{}),
+	}
+	go p.run()
+	return p, producer
+}
+
+func kafkaTestBatch(texts ...string) []*FileEvent {
+	source := "/var/log/app.log"
+	fileconfig := &FileConfig{MaxBytes: 4096}
+	var events []*FileEvent
+	for i := range texts {
+		events = append(events, fileconfig.newEvent(&source, &texts[i], int64(i), uint64(i+1)))
+	}
+	return events
+}
+
+func TestKafkaPublisherRetry(t *testing.T) {
+	p, producer := newMockPublisher(t, "10ms")
+
+	// the first message fails, it is sent again before the batch is acked
+	producer.ExpectInputAndFail(errors.New("not enough replicas"))
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndSucceed()
+
+	batch := kafkaTestBatch("first", "second")
+	p.Publish(batch)
+	select {
+	case acked := <-p.Acked():
+		if len(acked) != 2 || acked[0] != batch[0] {
+			t.Fatalf("Expected the batch to be acked, got %v", acked)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the batch to be acked after the retry")
+	}
+	chkerr(t, p.Close())
+}
+
+func TestKafkaPublisherCloseWhileRetrying(t *testing.T) {
+	p, producer := newMockPublisher(t, "1h")
+
+	producer.ExpectInputAndFail(errors.New("broker down"))
+	p.Publish(kafkaTestBatch("first"))
+
+	closed := make(chan error)
+	go func() { closed <- p.Close() }()
+	select {
+	case err := <-closed:
+		chkerr(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected Close not to wait for the retries")
+	}
+
+	// the batch is not acked, the registrar does not save its offsets
+	if acked, ok := <-p.Acked(); ok {
+		t.Fatalf("Expected the unsent batch not to be acked, got %v", acked)
+	}
+}
+
+func TestRequiredAcks(t *testing.T) {
+	expected := map[string]sarama.RequiredAcks{
+		"":               sarama.WaitForLocal,
+		"wait_for_local": sarama.WaitForLocal,
+		"wait_for_all":   sarama.WaitForAll,
+		"no_response":    sarama.NoResponse,
+	}
+	for ra, acks := range expected {
+		if requiredAcks(ra) != acks {
+			t.Errorf("Expected %q to be %d, got %d", ra, acks, requiredAcks(ra))
+		}
+	}
+}
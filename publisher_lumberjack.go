@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+)
+
+// NetworkConfig is the config of the lumberjack output
+type NetworkConfig struct {
+	Servers          []string `json:"servers"`           // ["localhost:5043"], one is picked at random
+	SSLCertificate   string   `json:"ssl certificate"`   // client certificate, optional
+	SSLKey           string   `json:"ssl key"`           // client key, optional
+	SSLCA            string   `json:"ssl ca"`            // trusted CA to verify the servers. plain tcp is used if neither ca nor certificate is set
+	Timeout          int64    `json:"timeout"`           // seconds to wait for an ack before reconnecting
+	WindowSize       int      `json:"window size"`       // max unacked data frames, default the whole batch
+	CompressionLevel int      `json:"compression level"` // zlib level 1-9, default 3
+
+	timeout time.Duration
+}
+
+const lumberjackCompressionLevel = 3
+
+// wait before reconnecting after a window was not acked
+var lumberjackReconnectDelay = time.Second
+
+// LumberjackPublisher is the Publisher sending events to logstash with the
+// lumberjack v1 protocol. batches are acked after the server acked the last
+// data frame of them.
+type LumberjackPublisher struct {
+	nconf     *NetworkConfig
+	tlsConfig *tls.Config
+	conn      net.Conn
+	sequence  uint32
+	input     chan []*FileEvent
+	acked     chan []*FileEvent
+	done      chan struct{}
+	closing   chan struct{} // closed by Close, stops the reconnects of sendBatch
+}
+
+func newLumberjackPublisher(config *Config) (Publisher, error) {
+	nconf := &config.Network
+	if len(nconf.Servers) == 0 {
+		return nil, errors.New("no servers in network section")
+	}
+
+	tlsConfig, err := nconf.loadTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &LumberjackPublisher{
+		nconf:     nconf,
+		tlsConfig: tlsConfig,
+		input:     make(chan []*FileEvent, 1),
+		acked:     make(chan []*FileEvent, 1),
+		done:      make(chan struct{}),
+		closing:   make(chan struct{}),
+	}
+	go p.run()
+	return p, nil
+}
+
+func (nconf *NetworkConfig) loadTLSConfig() (*tls.Config, error) {
+	if nconf.SSLCA == "" && nconf.SSLCertificate == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+
+	if nconf.SSLCertificate != "" && nconf.SSLKey != "" {
+		cert, err := tls.LoadX509KeyPair(nconf.SSLCertificate, nconf.SSLKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading client ssl certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if nconf.SSLCA != "" {
+		pemCerts, err := ioutil.ReadFile(nconf.SSLCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading ssl ca: %s", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pemCerts) {
+			return nil, fmt.Errorf("no certificate found in %s", nconf.SSLCA)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+func (p *LumberjackPublisher) Publish(events []*FileEvent) {
+	p.input <- events
+}
+
+func (p *LumberjackPublisher) Acked() <-chan []*FileEvent {
+	return p.acked
+}
+
+func (p *LumberjackPublisher) Close() error {
+	close(p.closing)
+	close(p.input)
+	<-p.done
+	p.disconnect()
+	return nil
+}
+
+func (p *LumberjackPublisher) run() {
+	defer close(p.done)
+	defer close(p.acked)
+
+	for events := range p.input {
+		if !p.sendBatch(events) {
+			// closed before the server acked the batch, it is sent again on restart
+			return
+		}
+		p.acked <- events
+	}
+}
+
+func lumberjackData(event *FileEvent) map[string]string {
+	data := map[string]string{
+		"message": *event.Text,
+		"line":    strconv.FormatUint(event.Line, 10),
+		"offset":  strconv.FormatInt(event.Offset, 10),
+	}
+	if event.NoPath == false {
+		data["path"] = *event.Source
+	}
+	if event.NoHostname == false {
+		data["host"] = *event.Hostname
+	}
+	for k, v := range *event.Fields {
+		data[k] = v
+	}
+	return data
+}
+
+// sendBatch does not return until the server acked all the events in the
+// batch. the batch is split into windows of nconf.WindowSize data frames, a
+// window is re-sent on a new connection if it was not acked in time. it
+// returns false if the publisher was closed before the batch was acked.
+func (p *LumberjackPublisher) sendBatch(events []*FileEvent) bool {
+	datas := make([]map[string]string, 0, len(events))
+	for _, event := range events {
+		// skip too long text, and the events only saved by the registrar
+		if event.StateOnly || len(*event.Text) > event.MaxBytes {
+			continue
+		}
+		datas = append(datas, lumberjackData(event))
+	}
+
+	windowSize := p.nconf.WindowSize
+	if windowSize <= 0 {
+		windowSize = len(datas)
+	}
+
+	for start := 0; start < len(datas); {
+		end := start + windowSize
+		if end > len(datas) {
+			end = len(datas)
+		}
+
+		if err := p.sendWindow(datas[start:end]); err != nil {
+			emit("lumberjack: %s, reconnect in %v\n", err, lumberjackReconnectDelay)
+			p.disconnect()
+			if !p.wait(lumberjackReconnectDelay) {
+				return false
+			}
+			continue
+		}
+		start = end
+	}
+	return true
+}
+
+// wait sleeps for d, it returns false if the publisher was closed meanwhile
+func (p *LumberjackPublisher) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-p.closing:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// sendWindow writes a window frame and then all the datas in one compressed
+// frame, and waits for the ack of the last one
+func (p *LumberjackPublisher) sendWindow(datas []map[string]string) error {
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+
+	var buffer bytes.Buffer
+	for _, data := range datas {
+		p.sequence++
+		writeDataFrame(&buffer, p.sequence, data)
+	}
+
+	level := p.nconf.CompressionLevel
+	if level == 0 {
+		level = lumberjackCompressionLevel
+	}
+
+	p.conn.SetDeadline(time.Now().Add(p.nconf.timeout))
+	if err := writeWindowFrame(p.conn, uint32(len(datas))); err != nil {
+		return err
+	}
+	if err := writeCompressedFrame(p.conn, buffer.Bytes(), level); err != nil {
+		return err
+	}
+
+	// acks may come in bulk, wait until the last sequence is acked
+	for {
+		p.conn.SetReadDeadline(time.Now().Add(p.nconf.timeout))
+		frame, err := readFrame(p.conn)
+		if err != nil {
+			return fmt.Errorf("read ack failed: %s", err)
+		}
+		if frame.Type != lumberjackFrameAck {
+			return fmt.Errorf("unexpected frame type %q while waiting for ack", frame.Type)
+		}
+		if frame.Sequence == p.sequence {
+			return nil
+		}
+	}
+}
+
+func (p *LumberjackPublisher) connect() error {
+	address := p.nconf.Servers[rand.Intn(len(p.nconf.Servers))]
+
+	conn, err := net.DialTimeout("tcp", address, p.nconf.timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %s", address, err)
+	}
+
+	if p.tlsConfig != nil {
+		// certificates must match the host we are connecting to
+		host, _, _ := net.SplitHostPort(address)
+		tlsConfig := p.tlsConfig.Clone()
+		tlsConfig.ServerName = host
+
+		tlsConn := tls.Client(conn, tlsConfig)
+		tlsConn.SetDeadline(time.Now().Add(p.nconf.timeout))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return fmt.Errorf("tls handshake with %s failed: %s", address, err)
+		}
+		conn = tlsConn
+	}
+
+	emit("lumberjack: connected to %s\n", address)
+	p.conn = conn
+	// sequence numbers start over on every connection
+	p.sequence = 0
+	return nil
+}
+
+func (p *LumberjackPublisher) disconnect() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeLumberjackServer reads one window of data frames and acks them, the
+// data frames are sent to frames.
+func fakeLumberjackServer(t *testing.T, ln net.Listener, frames chan *lumberjackFrame) {
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Errorf("accept failed: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	window, err := readFrame(conn)
+	if err != nil || window.Type != lumberjackFrameWindow {
+		t.Errorf("Expected window frame, got %v (%v)", window, err)
+		return
+	}
+
+	compressed, err := readFrame(conn)
+	if err != nil || compressed.Type != lumberjackFrameCompressed {
+		t.Errorf("Expected compressed frame, got %v (%v)", compressed, err)
+		return
+	}
+
+	payload := bytes.NewReader(compressed.Payload)
+	for i := uint32(0); i < window.Window; i++ {
+		frame, err := readFrame(payload)
+		if err != nil {
+			t.Errorf("Failed reading data frame: %s", err)
+			return
+		}
+		frames <- frame
+		// ack the first frame alone, then the rest in bulk
+		if i == 0 || i == window.Window-1 {
+			writeAckFrame(conn, frame.Sequence)
+		}
+	}
+}
+
+func TestLumberjackPublisher(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	chkerr(t, err)
+	defer ln.Close()
+
+	frames := make(chan *lumberjackFrame, 3)
+	go fakeLumberjackServer(t, ln, frames)
+
+	config := &Config{Network: NetworkConfig{Servers: []string{ln.Addr().String()}}}
+	FinalizeConfig(config)
+	p, err := newLumberjackPublisher(config)
+	chkerr(t, err)
+
+	source := "/var/log/test.log"
+	hostname := "testhost"
+	fields := map[string]string{"type": "test"}
+	var events []*FileEvent
+	for _, text := range []string{"line one", "line two", "line three"} {
+		text := text
+		events = append(events, &FileEvent{
+			Source:   &source,
+			Text:     &text,
+			Hostname: &hostname,
+			Fields:   &fields,
+			MaxBytes: 1024,
+		})
+	}
+
+	p.Publish(events)
+	acked := <-p.Acked()
+	if len(acked) != len(events) {
+		t.Fatalf("Expected %d events acked, got %d", len(events), len(acked))
+	}
+
+	for i, event := range events {
+		frame := <-frames
+		if frame.Sequence != uint32(i+1) {
+			t.Errorf("Expected sequence %d, got %d", i+1, frame.Sequence)
+		}
+		if frame.Data["message"] != *event.Text || frame.Data["type"] != "test" || frame.Data["host"] != hostname {
+			t.Errorf("Unexpected data frame: %v", frame.Data)
+		}
+	}
+	p.Close()
+}
+
+// dropLumberjackWindow reads one window without acking it
+func dropLumberjackWindow(t *testing.T, ln net.Listener) net.Conn {
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Errorf("accept failed: %s", err)
+		return nil
+	}
+	for _, expected := range []byte{lumberjackFrameWindow, lumberjackFrameCompressed} {
+		if frame, err := readFrame(conn); err != nil || frame.Type != expected {
+			t.Errorf("Expected frame %q, got %v (%v)", expected, frame, err)
+		}
+	}
+	return conn
+}
+
+func publishLumberjackTest(t *testing.T, ln net.Listener) (Publisher, []*FileEvent) {
+	config := &Config{Network: NetworkConfig{Servers: []string{ln.Addr().String()}}}
+	FinalizeConfig(config)
+	config.Network.timeout = 200 * time.Millisecond
+	p, err := newLumberjackPublisher(config)
+	chkerr(t, err)
+
+	source := "/var/log/test.log"
+	hostname := "testhost"
+	fields := map[string]string{}
+	var events []*FileEvent
+	for _, text := range []string{"line one", "line two", "line three"} {
+		text := text
+		events = append(events, &FileEvent{Source: &source, Text: &text, Hostname: &hostname, Fields: &fields, MaxBytes: 1024})
+	}
+	p.Publish(events)
+	return p, events
+}
+
+func TestLumberjackPublisherReconnect(t *testing.T) {
+	defer func(delay time.Duration) { lumberjackReconnectDelay = delay }(lumberjackReconnectDelay)
+	lumberjackReconnectDelay = 10 * time.Millisecond
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	chkerr(t, err)
+	defer ln.Close()
+
+	frames := make(chan *lumberjackFrame, 3)
+	go func() {
+		// the server goes away before acking the window
+		if conn := dropLumberjackWindow(t, ln); conn != nil {
+			conn.Close()
+		}
+		fakeLumberjackServer(t, ln, frames)
+	}()
+
+	p, events := publishLumberjackTest(t, ln)
+	defer p.Close()
+	if acked := <-p.Acked(); len(acked) != len(events) {
+		t.Fatalf("Expected %d events acked, got %d", len(events), len(acked))
+	}
+	// the window is sent again, sequences start over on the new connection
+	for i, event := range events {
+		if frame := <-frames; frame.Sequence != uint32(i+1) || frame.Data["message"] != *event.Text {
+			t.Errorf("Unexpected data frame %d %v", frame.Sequence, frame.Data)
+		}
+	}
+}
+
+func TestLumberjackPublisherTimeout(t *testing.T) {
+	defer func(delay time.Duration) { lumberjackReconnectDelay = delay }(lumberjackReconnectDelay)
+	lumberjackReconnectDelay = 10 * time.Millisecond
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	chkerr(t, err)
+	defer ln.Close()
+
+	frames := make(chan *lumberjackFrame, 3)
+	closed := make(chan error, 1)
+	go func() {
+		// the server never acks the window on the first connection
+		conn := dropLumberjackWindow(t, ln)
+		if conn == nil {
+			return
+		}
+		defer conn.Close()
+		fakeLumberjackServer(t, ln, frames)
+		_, err := readFrame(conn)
+		closed <- err
+	}()
+
+	p, events := publishLumberjackTest(t, ln)
+	defer p.Close()
+	select {
+	case acked := <-p.Acked():
+		if len(acked) != len(events) || len(frames) != len(events) {
+			t.Fatalf("Expected %d events acked, got %d", len(events), len(acked))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the window to be sent again after the ack timeout")
+	}
+	// the connection without ack was given up
+	if err := <-closed; err != io.EOF {
+		t.Errorf("Expected the first connection to be closed, got %v", err)
+	}
+}
+
+func TestLumberjackPublisherCloseWhileReconnecting(t *testing.T) {
+	defer func(delay time.Duration) { lumberjackReconnectDelay = delay }(lumberjackReconnectDelay)
+	lumberjackReconnectDelay = time.Hour
+
+	// the server is down
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	chkerr(t, err)
+	ln.Close()
+
+	p, _ := publishLumberjackTest(t, ln)
+	closed := make(chan error)
+	go func() { closed <- p.Close() }()
+	select {
+	case err := <-closed:
+		chkerr(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected Close not to wait for the reconnects")
+	}
+
+	// the batch is not acked, the registrar does not save its offsets
+	if acked, ok := <-p.Acked(); ok {
+		t.Fatalf("Expected the unsent batch not to be acked, got %d events", len(acked))
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+)
+
+// fakePublisher acks a batch as soon as it is published
+type fakePublisher struct {
+	acked chan []*FileEvent
+}
+
+func (p *fakePublisher) Publish(events []*FileEvent) { p.acked <- events }
+func (p *fakePublisher) Acked() <-chan []*FileEvent  { return p.acked }
+func (p *fakePublisher) Close() error                { close(p.acked); return nil }
+
+func TestNewPublisherUnknownOutput(t *testing.T) {
+	config := &Config{Output: []string{"nosuchoutput"}}
+	if _, err := NewPublisher(config); err == nil {
+		t.Fatalf("Expected an error for unknown output")
+	}
+}
+
+func TestMultiPublisher(t *testing.T) {
+	a := &fakePublisher{acked: make(chan []*FileEvent, 2)}
+	b := &fakePublisher{acked: make(chan []*FileEvent, 2)}
+	p := newMultiPublisher([]Publisher{a, b})
+
+	source := "/var/log/test.log"
+	batch1 := []*FileEvent{{Source: &source, Offset: 1}}
+	batch2 := []*FileEvent{{Source: &source, Offset: 2}}
+	p.Publish(batch1)
+	p.Publish(batch2)
+
+	if events := <-p.Acked(); events[0] != batch1[0] {
+		t.Fatalf("Expected first batch to be acked first, got offset %d", events[0].Offset)
+	}
+	if events := <-p.Acked(); events[0] != batch2[0] {
+		t.Fatalf("Expected second batch to be acked second, got offset %d", events[0].Offset)
+	}
+
+	p.Close()
+	if _, ok := <-p.Acked(); ok {
+		t.Fatalf("Expected acked channel to be closed after Close")
+	}
+}
+
+func TestMultiPublisherDrainsAllOnClose(t *testing.T) {
+	a := &fakePublisher{acked: make(chan []*FileEvent, 2)}
+	b := &fakePublisher{acked: make(chan []*FileEvent, 2)}
+	p := newMultiPublisher([]Publisher{a, b})
+
+	source := "/var/log/test.log"
+	batch1 := []*FileEvent{{Source: &source, Offset: 1}}
+	batch2 := []*FileEvent{{Source: &source, Offset: 2}}
+
+	p.Publish(batch1)
+	// a is closed before it acked the second batch, b acked it
+	p.mutex.Lock()
+	p.batches = append(p.batches, batch2)
+	p.mutex.Unlock()
+	b.Publish(batch2)
+	a.Close()
+	b.Close()
+
+	if events := <-p.Acked(); events[0] != batch1[0] {
+		t.Fatalf("Expected first batch to be acked, got offset %d", events[0].Offset)
+	}
+	if events, ok := <-p.Acked(); ok {
+		t.Fatalf("Expected the batch not acked by a not to be acked, got offset %d", events[0].Offset)
+	}
+	if len(b.acked) != 0 {
+		t.Fatalf("Expected the acks of b to be drained, %d left", len(b.acked))
+	}
+}
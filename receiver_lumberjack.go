@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ReceiverConfig is the config of the lumberjack receiver. logagent accepts
+// events from other forwarders on Listen, and ships them like the events read
+// from files. the forwarders get the ack of their events once the output
+// published them, or once they are stored if the disk queue is enabled.
+type ReceiverConfig struct {
+	Listen         string            `json:"listen"`          // "0.0.0.0:5043", receiver is disabled if empty
+	SSLCertificate string            `json:"ssl certificate"` // server certificate. plain tcp is used if not set
+	SSLKey         string            `json:"ssl key"`         // server key
+	SSLCA          string            `json:"ssl ca"`          // if set, clients must present a certificate signed by it
+	Fields         map[string]string `json:"fields"`          // added to every received event
+	MaxBytes       int
+}
+
+func (rconf *ReceiverConfig) listen() (net.Listener, error) {
+	if rconf.SSLCertificate == "" {
+		return net.Listen("tcp", rconf.Listen)
+	}
+
+	tlsConfig, err := serverTLSConfig(rconf.SSLCertificate, rconf.SSLKey, rconf.SSLCA)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Listen("tcp", rconf.Listen, tlsConfig)
+}
+
+// serverTLSConfig loads the certificate of a listener. if ca is set, clients
+// must present a certificate signed by it.
+func serverTLSConfig(certificate, key, ca string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certificate, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading ssl certificate: %s", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if ca != "" {
+		pemCerts, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading ssl ca: %s", err)
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(pemCerts) {
+			return nil, fmt.Errorf("no certificate found in %s", ca)
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// ReceiveLumberjack accepts lumberjack connections and sends the received
+// events to output. the sender gets the ack of a window after the registrar
+// got all the events of it, that is after they are published.
+func ReceiveLumberjack(rconf *ReceiverConfig, output chan *FileEvent) {
+	ln, err := rconf.listen()
+	if err != nil {
+		fault("Could not listen on %s: %s", rconf.Listen, err)
+	}
+	emit("lumberjack receiver listening on %s\n", rconf.Listen)
+	serveLumberjack(ln, rconf, output)
+}
+
+func serveLumberjack(ln net.Listener, rconf *ReceiverConfig, output chan *FileEvent) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			emit("lumberjack receiver: accept failed: %s\n", err)
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(time.Second)
+				continue
+			}
+			return
+		}
+		c := &lumberjackConnection{
+			conn:   conn,
+			rconf:  rconf,
+			source: "lumberjack://" + conn.RemoteAddr().String(),
+		}
+		go c.serve(output)
+	}
+}
+
+type lumberjackConnection struct {
+	conn   net.Conn
+	rconf  *ReceiverConfig
+	source string
+
+	window   uint32
+	sequence uint32
+	pending  []*FileEvent
+}
+
+func (c *lumberjackConnection) serve(output chan *FileEvent) {
+	defer c.conn.Close()
+	emit("lumberjack receiver: new connection from %s\n", c.conn.RemoteAddr())
+
+	reader := bufio.NewReader(c.conn)
+	for {
+		frame, err := readFrame(reader)
+		if err != nil {
+			if err != io.EOF {
+				emit("lumberjack receiver: %s: %s\n", c.conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		switch frame.Type {
+		case lumberjackFrameWindow:
+			c.window = frame.Window
+		case lumberjackFrameData:
+			c.handleData(frame)
+		case lumberjackFrameCompressed:
+			if err = c.handleCompressed(frame); err != nil {
+				emit("lumberjack receiver: %s: %s\n", c.conn.RemoteAddr(), err)
+				return
+			}
+			// the whole compressed frame is acked at once
+			err = c.flush(output)
+		default:
+			emit("lumberjack receiver: %s: unexpected frame type %q\n", c.conn.RemoteAddr(), frame.Type)
+			return
+		}
+		if err != nil {
+			emit("lumberjack receiver: %s: %s\n", c.conn.RemoteAddr(), err)
+			return
+		}
+
+		if uint32(len(c.pending)) >= c.window {
+			if err = c.flush(output); err != nil {
+				emit("lumberjack receiver: %s: %s\n", c.conn.RemoteAddr(), err)
+				return
+			}
+		}
+	}
+}
+
+func (c *lumberjackConnection) handleCompressed(frame *lumberjackFrame) error {
+	payload := bytes.NewReader(frame.Payload)
+	for {
+		inner, err := readFrame(payload)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch inner.Type {
+		case lumberjackFrameWindow:
+			c.window = inner.Window
+		case lumberjackFrameData:
+			c.handleData(inner)
+		default:
+			return fmt.Errorf("unexpected frame type %q in compressed frame", inner.Type)
+		}
+	}
+}
+
+// handleData turns a data frame into a FileEvent. "message", "path", "host",
+// "@timestamp", "line" and "offset" are mapped to the event, all other keys
+// become fields, a field with one of these keys would be written twice in the
+// json of the event.
+func (c *lumberjackConnection) handleData(frame *lumberjackFrame) {
+	c.sequence = frame.Sequence
+
+	text := frame.Data["message"]
+	source := c.source
+	if path, ok := frame.Data["path"]; ok {
+		source = path
+	}
+	hostname, hasHostname := frame.Data["host"]
+	line, _ := strconv.ParseUint(frame.Data["line"], 10, 64)
+	offset, _ := strconv.ParseInt(frame.Data["offset"], 10, 64)
+	// @timestamp is the time of the line if it could be parsed, the time it was
+	// received otherwise
+	timestamp, _ := parseLumberjackTimestamp(frame.Data["@timestamp"])
+
+	fields := make(map[string]string)
+	for k, v := range c.rconf.Fields {
+		fields[k] = v
+	}
+	for k, v := range frame.Data {
+		switch k {
+		case "message", "path", "host", "@timestamp", "line", "offset":
+		default:
+			fields[k] = v
+		}
+	}
+
+	maxBytes := c.rconf.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = 1024 * 1024
+	}
+
+	c.pending = append(c.pending, &FileEvent{
+		Source:     &source,
+		Offset:     offset,
+		Line:       line,
+		Text:       &text,
+		Fields:     &fields,
+		Hostname:   &hostname,
+		NoHostname: !hasHostname,
+		MaxBytes:   maxBytes,
+		Time:       timestamp,
+	})
+}
+
+// parseLumberjackTimestamp parses the @timestamp of a data frame, RFC 3339 or
+// milliseconds since the epoch like the @timestamp of the json we publish
+func parseLumberjackTimestamp(value string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// flush sends the pending events downstream, waits until the registrar got
+// the last one of them and then acks its sequence to the sender
+func (c *lumberjackConnection) flush(output chan *FileEvent) error {
+	if len(c.pending) == 0 {
+		return nil
+	}
+
+	done := make(chan struct{})
+	c.pending[len(c.pending)-1].ack = func() { close(done) }
+	for _, event := range c.pending {
+		output <- event
+	}
+	c.pending = nil
+	<-done
+
+	return writeAckFrame(c.conn, c.sequence)
+}
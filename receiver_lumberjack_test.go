@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLumberjackReceiver(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	chkerr(t, err)
+	defer ln.Close()
+
+	rconf := &ReceiverConfig{Fields: map[string]string{"relay": "yes"}}
+	received := make(chan *FileEvent, 16)
+	go serveLumberjack(ln, rconf, received)
+
+	config := &Config{Network: NetworkConfig{Servers: []string{ln.Addr().String()}, WindowSize: 2}}
+	FinalizeConfig(config)
+	p, err := newLumberjackPublisher(config)
+	chkerr(t, err)
+	defer p.Close()
+
+	source := "/var/log/test.log"
+	hostname := "edgehost"
+	fields := map[string]string{"type": "test"}
+	var events []*FileEvent
+	for _, text := range []string{"line one", "line two", "line three"} {
+		text := text
+		events = append(events, &FileEvent{
+			Source:   &source,
+			Text:     &text,
+			Hostname: &hostname,
+			Fields:   &fields,
+			MaxBytes: 1024,
+		})
+	}
+	p.Publish(events)
+
+	for _, event := range events {
+		e := <-received
+		if *e.Text != *event.Text || *e.Source != source || *e.Hostname != hostname {
+			t.Errorf("Unexpected event received: %s %s %s", *e.Text, *e.Source, *e.Hostname)
+		}
+		if (*e.Fields)["type"] != "test" || (*e.Fields)["relay"] != "yes" {
+			t.Errorf("Unexpected fields received: %v", *e.Fields)
+		}
+
+		select {
+		case <-p.Acked():
+			t.Fatalf("Batch acked before the registrar got the events")
+		default:
+		}
+
+		// what registrar does
+		if e.ack != nil {
+			e.ack()
+		}
+	}
+
+	if acked := <-p.Acked(); len(acked) != len(events) {
+		t.Fatalf("Expected %d events acked, got %d", len(events), len(acked))
+	}
+}
+
+// jsonKeys returns the keys of the json object in msg, duplicates included
+func jsonKeys(t *testing.T, msg string) []string {
+	dec := json.NewDecoder(strings.NewReader(msg))
+	if _, err := dec.Token(); err != nil {
+		t.Fatalf("Invalid json %s: %s", msg, err)
+	}
+	var keys []string
+	for dec.More() {
+		key, err := dec.Token()
+		chkerr(t, err)
+		var value json.RawMessage
+		chkerr(t, dec.Decode(&value))
+		keys = append(keys, key.(string))
+	}
+	return keys
+}
+
+func TestReceiverUniqueKeys(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	chkerr(t, err)
+	defer ln.Close()
+
+	received := make(chan *FileEvent, 1)
+	go serveLumberjack(ln, &ReceiverConfig{}, received)
+
+	config := &Config{Network: NetworkConfig{Servers: []string{ln.Addr().String()}}}
+	FinalizeConfig(config)
+	p, err := newLumberjackPublisher(config)
+	chkerr(t, err)
+	defer p.Close()
+
+	// a forwarder sending the @timestamp with the fields
+	source, text, hostname := "/var/log/test.log", "line one", "edgehost"
+	fields := map[string]string{"type": "test", "@timestamp": "2015-01-02T03:04:05.006Z"}
+	p.Publish([]*FileEvent{{Source: &source, Text: &text, Hostname: &hostname, Fields: &fields, MaxBytes: 1024}})
+
+	e := <-received
+	if _, ok := (*e.Fields)["@timestamp"]; ok {
+		t.Errorf("Expected @timestamp mapped to the event, got fields %v", *e.Fields)
+	}
+	seen := make(map[string]bool)
+	for _, key := range jsonKeys(t, JsonFormat(e)) {
+		if seen[key] {
+			t.Errorf("Duplicate key %q in %s", key, JsonFormat(e))
+		}
+		seen[key] = true
+	}
+	if decoded := decodeJsonFormat(t, e); decoded["@timestamp"] != float64(1420167845006) || decoded["host"] != hostname || decoded["path"] != source {
+		t.Errorf("Unexpected event received: %v", decoded)
+	}
+	e.ack()
+	<-p.Acked()
+}
+
+func TestReceiverOnlyConfig(t *testing.T) {
+	defer func(delay time.Duration) { lumberjackReconnectDelay = delay }(lumberjackReconnectDelay)
+	lumberjackReconnectDelay = 10 * time.Millisecond
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	chkerr(t, err)
+	address := ln.Addr().String()
+	ln.Close()
+
+	// no files, so no prospector sends on persist
+	config := &Config{Receiver: ReceiverConfig{Listen: address}}
+	restart := &ProspectorResume{files: make(map[string]*FileState), persist: make(chan *FileState)}
+	received := make(chan *FileEvent, 16)
+	started := make(chan map[string]*FileState, 1)
+	go func() { started <- startInputs(config, restart, received) }()
+	select {
+	case persist := <-started:
+		if len(persist) != 0 {
+			t.Errorf("Expected no state to persist, got %v", persist)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the inputs to start without prospectors")
+	}
+
+	pconfig := &Config{Network: NetworkConfig{Servers: []string{address}}}
+	FinalizeConfig(pconfig)
+	p, err := newLumberjackPublisher(pconfig)
+	chkerr(t, err)
+	defer p.Close()
+
+	source := "/var/log/test.log"
+	hostname := "edgehost"
+	text := "relayed line"
+	fields := map[string]string{}
+	p.Publish([]*FileEvent{{Source: &source, Text: &text, Hostname: &hostname, Fields: &fields, MaxBytes: 1024}})
+	select {
+	case e := <-received:
+		if *e.Text != text {
+			t.Errorf("Unexpected event received: %s", *e.Text)
+		}
+		e.ack()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the receiver to relay the event")
+	}
+	<-p.Acked()
+}
@@ -1,49 +1,207 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 )
 
-func Registrar(state map[string]*FileState, input chan []*FileEvent) {
+func Registrar(state map[string]*FileState, path string, cleanup *RegistryCleanupConfig, input chan []*FileEvent) {
 	for events := range input {
 		emit("Registrar: processing %d events\n", len(events))
+		now := time.Now()
 		// Take the last event found for each file source
 		for _, event := range events {
-			// skip stdin
-			if *event.Source == "-" {
+			if event.ack != nil {
+				event.ack()
+			}
+
+			if event.Cursor != "" {
+				state[*event.Source] = &FileState{Source: event.Source, Cursor: event.Cursor, LastSeen: now.Unix()}
+				continue
+			}
+
+			// skip stdin and events not read from files
+			if *event.Source == "-" || event.fileinfo == nil {
 				continue
 			}
 
 			ino, dev := file_ids(event.fileinfo)
 			state[*event.Source] = &FileState{
 				Source: event.Source,
-				// take the offset + length of the line + newline char and
-				// save it as the new starting offset.
-				// This issues a problem, if the EOL is a CRLF! Then on start it read the LF again and generates a event with an empty line
-				Offset: event.Offset + int64(len(*event.Text)) + 1, // REVU: this is begging for BUGs
-				Inode:  ino,
-				Device: dev,
+				// the harvester resumes after the bytes of the event, whatever
+				// the end of line, the multiline settings or the codec
+				Offset:          event.EndOffset,
+				Inode:           ino,
+				Device:          dev,
+				Done:            event.Done,
+				Fingerprint:     event.Fingerprint,
+				FingerprintSize: event.FingerprintSize,
+				LastSeen:        now.Unix(),
 			}
 			//log.Printf("State %s: %d\n", *event.Source, event.Offset)
 		}
 
-		if e := writeRegistry(state, ".logstash-forwarder"); e != nil {
+		// Forget the files which are not harvested anymore
+		cleanup.clean(state, now)
+
+		if e := writeRegistry(state, path); e != nil {
 			// REVU: but we should panic, or something, right?
 			emit("WARNING: (continuing) update of registry returned error: %s", e)
 		}
 	}
 }
 
+// the registry is in the working directory by default
+const defaultRegistryFile = ".logstash-forwarder"
+
+var registryNamespaceRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// registryPath returns the absolute path of the registry of config, the
+// flags override the config
+func registryPath(config *Config) (string, error) {
+	path := options.registryFile
+	if path == "" {
+		path = config.RegistryFile
+	}
+	if path == "" {
+		path = defaultRegistryFile
+	}
+
+	namespace := options.registryNamespace
+	if namespace == "" {
+		namespace = config.RegistryNamespace
+	}
+	if namespace != "" {
+		if !registryNamespaceRegexp.MatchString(namespace) || strings.Trim(namespace, ".") == "" {
+			return "", fmt.Errorf("invalid namespace %q, only letters, digits, '_', '-' and '.' are allowed", namespace)
+		}
+		path += "." + namespace
+	}
+	return filepath.Abs(path)
+}
+
+// registryVersion is the version of the registry format written. version 1,
+// the map of the states alone, is migrated when it is loaded.
+const registryVersion = 2
+
+// registryFile is the registry as it is on disk. Checksum is the sha256 of
+// States, to detect files which were not completely written.
+type registryFile struct {
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"`
+	States   json.RawMessage `json:"states"`
+}
+
+// writeRegistry writes the states to a temporary file, syncs it and renames
+// it to path, so path is always a complete registry, the old or the new one
 func writeRegistry(state map[string]*FileState, path string) error {
-	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_SYNC, 0666)
+	states, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&registryFile{
+		Version:  registryVersion,
+		Checksum: fmt.Sprintf("%x", sha256.Sum256(states)),
+		States:   states,
+	})
+	if err != nil {
+		return err
+	}
+
+	tempfile := path + ".new"
+	file, err := os.OpenFile(tempfile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
-		emit("Failed to open state file (%s) for writing: %s\n", path, err)
+		emit("Failed to open state file (%s) for writing: %s\n", tempfile, err)
 		return err
 	}
-	defer file.Close()
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if e := file.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		emit("Failed to write state file (%s): %s\n", tempfile, err)
+		return err
+	}
+	return onRegistryWrite(path, tempfile)
+}
+
+// readRegistry reads the states of the registry at path, in the current or
+// in the old format
+func readRegistry(path string) (map[string]*FileState, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the old format was written in place, it could be followed by what was
+	// left of a longer registry
+	var fields map[string]json.RawMessage
+	if err = json.NewDecoder(bytes.NewReader(data)).Decode(&fields); err != nil {
+		return nil, err
+	}
+	var version int
+	if json.Unmarshal(fields["version"], &version) != nil || version == 0 {
+		emit("Migrating registry %s from version 1 to %d\n", path, registryVersion)
+		states := make(map[string]*FileState, len(fields))
+		for source, field := range fields {
+			var state FileState
+			if err = json.Unmarshal(field, &state); err != nil {
+				return nil, fmt.Errorf("state of %s: %s", source, err)
+			}
+			states[source] = &state
+		}
+		return states, nil
+	}
+
+	var registry registryFile
+	if err = json.Unmarshal(data, &registry); err != nil {
+		return nil, err
+	}
+	if registry.Version > registryVersion {
+		return nil, fmt.Errorf("version %d is newer than the supported %d", registry.Version, registryVersion)
+	}
+	if checksum := fmt.Sprintf("%x", sha256.Sum256(registry.States)); checksum != registry.Checksum {
+		return nil, fmt.Errorf("checksum %s does not match the states, %s", registry.Checksum, checksum)
+	}
+	states := make(map[string]*FileState)
+	if err = json.Unmarshal(registry.States, &states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
 
-	encoder := json.NewEncoder(file)
-	encoder.Encode(state)
-	return nil
+// loadRegistry loads the states of the registry at path. a corrupt registry
+// is moved aside, and the temporary file of the last write is used instead if
+// it is complete. without one, the files are harvested as new ones.
+func loadRegistry(path string) map[string]*FileState {
+	states, err := readRegistry(path)
+	if err == nil {
+		return states
+	}
+	if os.IsNotExist(err) {
+		return make(map[string]*FileState)
+	}
+
+	emit("WARNING: registry %s is corrupt: %s\n", path, err)
+	corrupt := fmt.Sprintf("%s.corrupt-%d", path, time.Now().Unix())
+	if e := os.Rename(path, corrupt); e == nil {
+		emit("Moved the corrupt registry to %s\n", corrupt)
+	}
+
+	if states, err = readRegistry(path + ".new"); err == nil {
+		emit("Recovered the registry from %s.new\n", path)
+		return states
+	}
+	emit("WARNING: could not recover the registry, all files are new\n")
+	return make(map[string]*FileState)
 }
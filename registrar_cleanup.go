@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// RegistryCleanupConfig removes the states of the files which are not
+// harvested anymore from the registry, which would grow forever otherwise
+// removed: remove the states of the files deleted, or replaced by another file, unless they were renamed in their directory
+// ttl: remove the states not updated for this long whose file is gone, "168h". quiet files are kept
+// max_entries: keep at most this many states of files, the most recently updated ones
+// the journald cursors are never removed
+// interval: how often the registry is cleaned up, default 1m
+type RegistryCleanupConfig struct {
+	Removed    bool   `json:"removed"`
+	TTL        string `json:"ttl"`
+	MaxEntries int    `json:"max_entries"`
+	Interval   string `json:"interval"`
+
+	ttl      time.Duration
+	interval time.Duration
+	last     time.Time
+}
+
+func (rc *RegistryCleanupConfig) compile() (err error) {
+	if rc.TTL != "" {
+		if rc.ttl, err = time.ParseDuration(rc.TTL); err != nil {
+			return fmt.Errorf("registry_cleanup: invalid ttl: %s", err)
+		}
+	}
+	rc.interval = time.Minute
+	if rc.Interval != "" {
+		if rc.interval, err = time.ParseDuration(rc.Interval); err != nil {
+			return fmt.Errorf("registry_cleanup: invalid interval: %s", err)
+		}
+	}
+	if rc.MaxEntries < 0 {
+		return fmt.Errorf("registry_cleanup: invalid max_entries %d", rc.MaxEntries)
+	}
+	return nil
+}
+
+func (rc *RegistryCleanupConfig) enabled() bool {
+	return rc != nil && (rc.Removed || rc.ttl > 0 || rc.MaxEntries > 0)
+}
+
+// clean removes the states matching the policies, at most every interval
+func (rc *RegistryCleanupConfig) clean(state map[string]*FileState, now time.Time) {
+	if !rc.enabled() || now.Sub(rc.last) < rc.interval {
+		return
+	}
+	rc.last = now
+
+	// states saved before last_seen was are as old as the start of the clean up
+	for _, s := range state {
+		if s.LastSeen == 0 {
+			s.LastSeen = now.Unix()
+		}
+	}
+
+	dirs := make(map[string][]os.FileInfo)
+	if rc.Removed {
+		for source, s := range state {
+			if s.Cursor != "" {
+				continue
+			}
+			if reason := removedReason(source, s, dirs); reason != "" {
+				emit("Registry cleanup: removing %s, %s\n", source, reason)
+				delete(state, source)
+			}
+		}
+	}
+
+	if rc.ttl > 0 {
+		for source, s := range state {
+			if s.Cursor != "" {
+				continue
+			}
+			age := now.Sub(time.Unix(s.LastSeen, 0))
+			// files still there are only quiet, they would be read from their end if they were forgotten
+			if age <= rc.ttl || removedReason(source, s, dirs) == "" {
+				continue
+			}
+			emit("Registry cleanup: removing %s, not updated for %v and the file is gone\n", source, age)
+			delete(state, source)
+		}
+	}
+
+	sources := make([]string, 0, len(state))
+	for source, s := range state {
+		if s.Cursor == "" {
+			sources = append(sources, source)
+		}
+	}
+	if rc.MaxEntries > 0 && len(sources) > rc.MaxEntries {
+		sort.Slice(sources, func(i, j int) bool {
+			return state[sources[i]].LastSeen > state[sources[j]].LastSeen
+		})
+		for _, source := range sources[rc.MaxEntries:] {
+			emit("Registry cleanup: removing %s, more than %d entries\n", source, rc.MaxEntries)
+			delete(state, source)
+		}
+	}
+}
+
+// removedReason returns why the file of the state is gone, or "" if it is
+// still there, under its name or renamed in its directory. the files of the
+// directories are read once per clean up.
+func removedReason(source string, s *FileState, dirs map[string][]os.FileInfo) string {
+	info, err := os.Stat(source)
+	if err == nil && is_file_same(source, info, s) {
+		return ""
+	}
+	if err != nil && !os.IsNotExist(err) {
+		// unknown, keep it
+		return ""
+	}
+
+	dir := filepath.Dir(source)
+	files, ok := dirs[dir]
+	if !ok {
+		files, _ = ioutil.ReadDir(dir)
+		dirs[dir] = files
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file.Name())
+		if path != source && file.Mode().IsRegular() && is_file_same(path, file, s) {
+			return ""
+		}
+	}
+
+	if err != nil {
+		return "the file was deleted"
+	}
+	return "the file was replaced by another one"
+}
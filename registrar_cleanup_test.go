@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// registerFiles runs the events of files through the Registrar
+func registerFiles(t *testing.T, tmpdir string, paths ...string) map[string]*FileState {
+	var events []*FileEvent
+	for _, path := range paths {
+		chkerr(t, ioutil.WriteFile(path, []byte("line of "+path+"\n"), 0644))
+		info, err := os.Stat(path)
+		chkerr(t, err)
+		path, text := path, "line"
+		events = append(events, &FileEvent{Source: &path, Text: &text, fileinfo: &info})
+	}
+
+	state := make(map[string]*FileState)
+	input := make(chan []*FileEvent, 1)
+	input <- events
+	close(input)
+	Registrar(state, filepath.Join(tmpdir, ".logstash-forwarder"), nil, input)
+	return state
+}
+
+func TestRegistryCleanupRemoved(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	deleted := filepath.Join(tmpdir, "deleted.log")
+	renamed := filepath.Join(tmpdir, "renamed.log")
+	kept := filepath.Join(tmpdir, "kept.log")
+	state := registerFiles(t, tmpdir, deleted, renamed, kept)
+	cursor := "journald"
+	state[cursor] = &FileState{Source: &cursor, Cursor: "s=1"}
+
+	chkerr(t, os.Remove(deleted))
+	chkerr(t, os.Rename(renamed, renamed+".1"))
+
+	rc := &RegistryCleanupConfig{Removed: true}
+	chkerr(t, rc.compile())
+	rc.clean(state, time.Now())
+
+	if _, ok := state[deleted]; ok {
+		t.Fatalf("Expected the state of the deleted file to be removed")
+	}
+	for _, source := range []string{renamed, kept, cursor} {
+		if _, ok := state[source]; !ok {
+			t.Fatalf("Expected the state of %s to be kept", source)
+		}
+	}
+}
+
+func TestRegistryCleanupTTLAndMaxEntries(t *testing.T) {
+	now := time.Now()
+	state := registryStates("/var/log/a.log", "/var/log/b.log", "/var/log/c.log", "/var/log/d.log")
+	state["/var/log/a.log"].LastSeen = now.Add(-48 * time.Hour).Unix()
+	state["/var/log/b.log"].LastSeen = now.Add(-3 * time.Hour).Unix()
+	state["/var/log/c.log"].LastSeen = now.Add(-2 * time.Hour).Unix()
+	// saved before last_seen, as old as the clean up
+	state["/var/log/d.log"].LastSeen = 0
+
+	rc := &RegistryCleanupConfig{TTL: "24h", MaxEntries: 2}
+	chkerr(t, rc.compile())
+	rc.clean(state, now)
+
+	if len(state) != 2 || state["/var/log/c.log"] == nil || state["/var/log/d.log"] == nil {
+		t.Fatalf("Expected the states of c.log and d.log to be kept, got %v", state)
+	}
+	if state["/var/log/d.log"].LastSeen != now.Unix() {
+		t.Fatalf("Expected the state without last_seen to be seen now, got %d", state["/var/log/d.log"].LastSeen)
+	}
+
+	// nothing happens before the interval
+	state["/var/log/c.log"].LastSeen = now.Add(-48 * time.Hour).Unix()
+	rc.clean(state, now.Add(30*time.Second))
+	if len(state) != 2 {
+		t.Fatalf("Expected no clean up before the interval, got %v", state)
+	}
+	rc.clean(state, now.Add(time.Minute))
+	if len(state) != 1 || state["/var/log/d.log"] == nil {
+		t.Fatalf("Expected the expired state to be removed, got %v", state)
+	}
+}
+
+func TestRegistryCleanupConfig(t *testing.T) {
+	var rc *RegistryCleanupConfig
+	if rc.enabled() {
+		t.Fatalf("Expected no clean up without config")
+	}
+	for _, invalid := range []RegistryCleanupConfig{{TTL: "a week"}, {Interval: "1"}, {MaxEntries: -1}} {
+		if err := invalid.compile(); err == nil {
+			t.Fatalf("Expected an error compiling %+v", invalid)
+		}
+	}
+}
+
+func TestRegistryCleanupTTLKeepsQuietFiles(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	quiet := filepath.Join(tmpdir, "quiet.log")
+	state := registerFiles(t, tmpdir, quiet)
+	cursor := "journald"
+	state[cursor] = &FileState{Source: &cursor, Cursor: "s=1"}
+	old := time.Now().Add(-48 * time.Hour).Unix()
+	state[quiet].LastSeen, state[cursor].LastSeen = old, old
+
+	rc := &RegistryCleanupConfig{TTL: "24h", MaxEntries: 1}
+	chkerr(t, rc.compile())
+	rc.clean(state, time.Now())
+	if state[quiet] == nil || state[cursor] == nil {
+		t.Fatalf("Expected the quiet file and the journald cursor to be kept, got %v", state)
+	}
+
+	// expired once the file is gone
+	chkerr(t, os.Remove(quiet))
+	rc.clean(state, time.Now().Add(time.Minute))
+	if state[quiet] != nil || state[cursor] == nil {
+		t.Fatalf("Expected only the state of the deleted file to expire, got %v", state)
+	}
+}
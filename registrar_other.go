@@ -3,7 +3,12 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 )
 
 func onRegistryWrite(path, tempfile string) error {
@@ -11,5 +16,32 @@ func onRegistryWrite(path, tempfile string) error {
 		emit("registry rotate: rename of %s to %s - %s\n", tempfile, path, e)
 		return e
 	}
+
+	// the rename is durable once the directory is synced
+	if dir, e := os.Open(filepath.Dir(path)); e == nil {
+		dir.Sync()
+		dir.Close()
+	}
 	return nil
 }
+
+// lockRegistry locks path.lock, which has the pid of the instance using the
+// registry. the lock is released when the file is closed, or the process exits.
+func lockRegistry(path string) (*os.File, error) {
+	lockfile := path + ".lock"
+	file, err := os.OpenFile(lockfile, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		pid, _ := ioutil.ReadAll(file)
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("%s is used by another instance, pid %s", path, strings.TrimSpace(string(pid)))
+		}
+		return nil, fmt.Errorf("%s: %s", lockfile, err)
+	}
+	file.Truncate(0)
+	fmt.Fprintf(file, "%d\n", os.Getpid())
+	return file, nil
+}
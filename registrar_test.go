@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func registryStates(sources ...string) map[string]*FileState {
+	states := make(map[string]*FileState)
+	for i, source := range sources {
+		source := source
+		states[source] = &FileState{Source: &source, Offset: int64(100 * (i + 1)), Inode: uint64(i + 1)}
+	}
+	return states
+}
+
+func TestWriteRegistry(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+	path := filepath.Join(tmpdir, ".logstash-forwarder")
+
+	chkerr(t, writeRegistry(registryStates("/var/log/a.log", "/var/log/with-a-long-name.log"), path))
+	// a smaller registry leaves nothing of the bigger one
+	chkerr(t, writeRegistry(registryStates("/var/log/a.log"), path))
+
+	data, err := ioutil.ReadFile(path)
+	chkerr(t, err)
+	var registry registryFile
+	chkerr(t, json.Unmarshal(data, &registry))
+	if registry.Version != registryVersion || registry.Checksum == "" {
+		t.Fatalf("Unexpected registry %s", data)
+	}
+	if _, err = os.Stat(path + ".new"); !os.IsNotExist(err) {
+		t.Fatalf("Expected the temporary file to be renamed, got %v", err)
+	}
+
+	states, err := readRegistry(path)
+	chkerr(t, err)
+	if len(states) != 1 || states["/var/log/a.log"].Offset != 100 || *states["/var/log/a.log"].Source != "/var/log/a.log" {
+		t.Fatalf("Unexpected states %v", states)
+	}
+}
+
+func TestReadRegistryMigration(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+	path := filepath.Join(tmpdir, ".logstash-forwarder")
+
+	// version 1 was written in place, what is left of a longer registry follows it
+	old := `{"/var/log/a.log":{"source":"/var/log/a.log","offset":42,"inode":7,"device":2049}}` + "\n" + `fset":10}}` + "\n"
+	chkerr(t, ioutil.WriteFile(path, []byte(old), 0644))
+
+	states, err := readRegistry(path)
+	chkerr(t, err)
+	state := states["/var/log/a.log"]
+	if len(states) != 1 || state == nil || state.Offset != 42 || state.Inode != 7 || state.Device != 2049 {
+		t.Fatalf("Unexpected migrated states %v", states)
+	}
+
+	// it is written in the current format
+	chkerr(t, writeRegistry(states, path))
+	data, err := ioutil.ReadFile(path)
+	chkerr(t, err)
+	if !strings.HasPrefix(string(data), `{"version":2,`) {
+		t.Fatalf("Expected the registry to be migrated, got %s", data)
+	}
+}
+
+func TestReadRegistryErrors(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+	path := filepath.Join(tmpdir, ".logstash-forwarder")
+
+	chkerr(t, writeRegistry(registryStates("/var/log/a.log"), path))
+	data, err := ioutil.ReadFile(path)
+	chkerr(t, err)
+
+	tests := map[string]string{
+		"truncated": string(data[:len(data)/2]),
+		"checksum":  strings.Replace(string(data), `"offset":100`, `"offset":900`, 1),
+		"version":   strings.Replace(string(data), `"version":2`, `"version":3`, 1),
+	}
+	for name, content := range tests {
+		chkerr(t, ioutil.WriteFile(path, []byte(content), 0644))
+		if _, err = readRegistry(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadRegistryRecovery(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+	path := filepath.Join(tmpdir, ".logstash-forwarder")
+
+	if states := loadRegistry(path); len(states) != 0 {
+		t.Fatalf("Expected no states without registry, got %v", states)
+	}
+
+	// a crash after the temporary file was synced, before the rename
+	chkerr(t, writeRegistry(registryStates("/var/log/a.log", "/var/log/b.log"), path))
+	chkerr(t, os.Rename(path, path+".new"))
+	chkerr(t, ioutil.WriteFile(path, []byte(`{"version":2,"checksum":"`), 0644))
+
+	states := loadRegistry(path)
+	if len(states) != 2 || states["/var/log/b.log"].Offset != 200 {
+		t.Fatalf("Expected the states of the temporary file, got %v", states)
+	}
+	corrupt, _ := filepath.Glob(path + ".corrupt-*")
+	if len(corrupt) != 1 {
+		t.Fatalf("Expected the corrupt registry to be moved aside, got %v", corrupt)
+	}
+
+	// without a complete temporary file, all files are new
+	chkerr(t, ioutil.WriteFile(path, []byte("garbage"), 0644))
+	chkerr(t, ioutil.WriteFile(path+".new", []byte(`{"version":2`), 0644))
+	if states = loadRegistry(path); len(states) != 0 {
+		t.Fatalf("Expected no states, got %v", states)
+	}
+}
+
+func TestRegistryPath(t *testing.T) {
+	wd, _ := os.Getwd()
+	config := &Config{}
+	path, err := registryPath(config)
+	chkerr(t, err)
+	if path != filepath.Join(wd, ".logstash-forwarder") {
+		t.Fatalf("Expected the default registry in the working directory, got %s", path)
+	}
+
+	config = &Config{RegistryFile: "/var/lib/logagent/registry", RegistryNamespace: "apache"}
+	path, err = registryPath(config)
+	chkerr(t, err)
+	if path != filepath.Clean("/var/lib/logagent/registry.apache") {
+		t.Fatalf("Expected the namespaced registry, got %s", path)
+	}
+
+	// the flags override the config
+	defer func() { options.registryFile, options.registryNamespace = "", "" }()
+	options.registryFile, options.registryNamespace = "/tmp/registry", "syslog"
+	path, err = registryPath(config)
+	chkerr(t, err)
+	if path != filepath.Clean("/tmp/registry.syslog") {
+		t.Fatalf("Expected the registry of the flags, got %s", path)
+	}
+
+	for _, namespace := range []string{"../etc", "a/b", ".."} {
+		options.registryNamespace = namespace
+		if _, err = registryPath(config); err == nil {
+			t.Errorf("Expected namespace %q to be invalid", namespace)
+		}
+	}
+}
+
+func TestLockRegistry(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+	path := filepath.Join(tmpdir, ".logstash-forwarder")
+
+	lock, err := lockRegistry(path)
+	chkerr(t, err)
+	if _, err = lockRegistry(path); err == nil || !strings.Contains(err.Error(), "another instance") {
+		t.Fatalf("Expected the registry to be locked, got %v", err)
+	}
+
+	// it is released when the instance exits
+	lock.Close()
+	lock, err = lockRegistry(path)
+	chkerr(t, err)
+	lock.Close()
+}
@@ -1 +1,40 @@
 package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func onRegistryWrite(path, tempfile string) error {
+	// os.Rename replaces path on windows too, with MoveFileEx
+	if e := os.Rename(tempfile, path); e != nil {
+		emit("registry rotate: rename of %s to %s - %s\n", tempfile, path, e)
+		return e
+	}
+	return nil
+}
+
+// ERROR_SHARING_VIOLATION is not in syscall
+const errorSharingViolation syscall.Errno = 32
+
+// lockRegistry opens path.lock without sharing it, no other instance could
+// open it until the file is closed, or the process exits
+func lockRegistry(path string) (*os.File, error) {
+	lockfile := path + ".lock"
+	pathp, err := syscall.UTF16PtrFromString(lockfile)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(pathp, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		if err == errorSharingViolation {
+			return nil, fmt.Errorf("%s is used by another instance", path)
+		}
+		return nil, fmt.Errorf("%s: %s", lockfile, err)
+	}
+	file := os.NewFile(uintptr(h), lockfile)
+	file.Truncate(0)
+	fmt.Fprintf(file, "%d\n", os.Getpid())
+	return file, nil
+}
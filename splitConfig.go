@@ -1,16 +1,241 @@
 package main
 
 import (
+	"fmt"
 	"regexp"
+	"strings"
+	"time"
 )
 
 func SplitConf(config *Config) (err error) {
 	for idx, _ := range config.Files {
+		config.Files[idx].id = fmt.Sprintf("files/%d", idx)
+		if err = splitFileConfig(&config.Files[idx]); err != nil {
+			return err
+		}
+	}
+
+	for idx, _ := range config.Listeners {
+		config.Listeners[idx].id = fmt.Sprintf("listeners/%d", idx)
+		if err = splitFileConfig(&config.Listeners[idx].FileConfig); err != nil {
+			return err
+		}
+		if err = config.Listeners[idx].check(); err != nil {
+			return err
+		}
+	}
+
+	for idx, _ := range config.Journald {
+		config.Journald[idx].id = fmt.Sprintf("journald/%d", idx)
+		if err = splitFileConfig(&config.Journald[idx].FileConfig); err != nil {
+			return err
+		}
+	}
+
+	if err = config.RegistryCleanup.compile(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// fileConfigs are the configs of the inputs by their id, events replayed from
+// the disk queue are parsed with the config they were read with
+func (config *Config) fileConfigs() map[string]*FileConfig {
+	configs := make(map[string]*FileConfig)
+	for idx := range config.Files {
+		configs[config.Files[idx].id] = &config.Files[idx]
+	}
+	for idx := range config.Listeners {
+		configs[config.Listeners[idx].id] = &config.Listeners[idx].FileConfig
+	}
+	for idx := range config.Journald {
+		configs[config.Journald[idx].id] = &config.Journald[idx].FileConfig
+	}
+	return configs
+}
+
+// splitFileConfig compiles the parsing settings of a file or a listener
+func splitFileConfig(fileconfig *FileConfig) (err error) {
+	fileconfig.DelimiterRegexp = regexp.MustCompile(fileconfig.Delimiter)
+
+	fileconfig.FieldNamesLength = len(fileconfig.FieldNames)
+
+	if err = checkFieldTypes(fileconfig); err != nil {
+		return err
+	}
+
+	if err = compilePattern(fileconfig); err != nil {
+		return err
+	}
+
+	if err = checkCodec(fileconfig); err != nil {
+		return err
+	}
+
+	if err = compileExcludeFiles(fileconfig); err != nil {
+		return err
+	}
+
+	if err = compileLineFilters(fileconfig); err != nil {
+		return err
+	}
+
+	if fileconfig.Kubernetes != nil {
+		if err = fileconfig.Kubernetes.compile(fileconfig); err != nil {
+			return err
+		}
+	}
+
+	switch fileconfig.Container {
+	case "", "docker", "cri", "auto":
+	default:
+		return fmt.Errorf("unknown container log format %q, should be docker, cri or auto", fileconfig.Container)
+	}
+
+	if fileconfig.Timestamp != nil {
+		if err = fileconfig.Timestamp.compile(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkFieldType(fieldType string) error {
+	switch {
+	case fieldType == "string", fieldType == "integer", fieldType == "float", fieldType == "boolean":
+	case strings.HasPrefix(fieldType, "timestamp:"):
+	default:
+		return fmt.Errorf("unknown field type %q", fieldType)
+	}
+	return nil
+}
+
+func checkFieldTypes(fileconfig *FileConfig) error {
+	if len(fileconfig.FieldTypes) > 0 && len(fileconfig.FieldTypes) != len(fileconfig.FieldNames) {
+		return fmt.Errorf("%d FieldTypes given for %d FieldNames", len(fileconfig.FieldTypes), len(fileconfig.FieldNames))
+	}
+	for _, fieldType := range fileconfig.FieldTypes {
+		if err := checkFieldType(fieldType); err != nil {
+			return err
+		}
+	}
+
+	switch fileconfig.ConversionFailure {
+	case "", "keep", "drop", "tag":
+	default:
+		return fmt.Errorf("unknown ConversionFailure %q, should be keep, drop or tag", fileconfig.ConversionFailure)
+	}
+	return nil
+}
+
+func compilePattern(fileconfig *FileConfig) (err error) {
+	if fileconfig.Pattern == "" && fileconfig.Grok == "" {
+		return nil
+	}
+	if fileconfig.Pattern != "" && fileconfig.Grok != "" {
+		return fmt.Errorf("Pattern and Grok could not be used together")
+	}
+	if len(fileconfig.FieldNames) > 0 {
+		return fmt.Errorf("Pattern and FieldNames could not be used together")
+	}
+
+	pattern := fileconfig.Pattern
+	if fileconfig.Grok != "" {
+		patterns, err := loadGrokPatterns(fileconfig.GrokPatternFiles)
+		if err != nil {
+			return fmt.Errorf("could not load grok patterns: %s", err)
+		}
+		if fileconfig.CaptureTypes == nil {
+			fileconfig.CaptureTypes = make(map[string]string)
+		}
+		fileconfig.CaptureNames = make(map[string]string)
+		if pattern, err = expandGrok(fileconfig.Grok, patterns, fileconfig.CaptureTypes, fileconfig.CaptureNames); err != nil {
+			return err
+		}
+	}
+	if fileconfig.ExactMatch {
+		pattern = "^(?:" + pattern + ")$"
+	}
+	if fileconfig.PatternRegexp, err = regexp.Compile(pattern); err != nil {
+		return fmt.Errorf("could not compile pattern %q: %s", pattern, err)
+	}
+
+	fields := make(map[string]bool)
+	for _, name := range fileconfig.PatternRegexp.SubexpNames() {
+		if field, ok := fileconfig.CaptureNames[name]; ok {
+			name = field
+		}
+		fields[name] = true
+	}
+	for name, fieldType := range fileconfig.CaptureTypes {
+		if !fields[name] {
+			return fmt.Errorf("CaptureTypes: no group named %q in pattern", name)
+		}
+		if err = checkFieldType(fieldType); err != nil {
+			return err
+		}
+	}
 
-		config.Files[idx].DelimiterRegexp = regexp.MustCompile(config.Files[idx].Delimiter)
+	switch fileconfig.PatternFailure {
+	case "", "message", "tag":
+	default:
+		return fmt.Errorf("unknown PatternFailure %q, should be message or tag", fileconfig.PatternFailure)
+	}
+	return nil
+}
 
-		config.Files[idx].FieldNamesLength = len(config.Files[idx].FieldNames)
+func checkCodec(fileconfig *FileConfig) error {
+	switch fileconfig.Codec {
+	case "", "plain":
+		return nil
+	case "json", "logfmt", "kv", "csv", "tsv", "w3c", "syslog":
+	default:
+		return fmt.Errorf("unknown codec %q", fileconfig.Codec)
+	}
+	if fileconfig.PatternRegexp != nil {
+		return fmt.Errorf("codec %s could not be used with Pattern", fileconfig.Codec)
+	}
+	if len(fileconfig.FieldNames) > 0 && !isCSVCodec(fileconfig.Codec) {
+		return fmt.Errorf("codec %s could not be used with FieldNames", fileconfig.Codec)
 	}
 
+	switch fileconfig.Codec {
+	case "json":
+		if fileconfig.JSON != nil {
+			return fileconfig.JSON.check()
+		}
+	case "logfmt", "kv", "syslog":
+		// @timestamp is the time of the syslog message, not when it was read
+		if fileconfig.Codec == "syslog" && fileconfig.Timestamp == nil {
+			fileconfig.Timestamp = &TimestampConfig{Field: "timestamp", Layouts: []string{time.RFC3339Nano, rfc3164Layout}}
+		}
+		for _, fieldType := range fileconfig.CaptureTypes {
+			if err := checkFieldType(fieldType); err != nil {
+				return err
+			}
+		}
+		if fileconfig.Codec == "kv" && fileconfig.KV != nil {
+			return fileconfig.KV.check()
+		}
+	case "csv", "tsv", "w3c":
+		for idx, fieldType := range fileconfig.FieldTypes {
+			if fileconfig.CaptureTypes == nil {
+				fileconfig.CaptureTypes = make(map[string]string)
+			}
+			if _, ok := fileconfig.CaptureTypes[fileconfig.FieldNames[idx]]; !ok {
+				fileconfig.CaptureTypes[fileconfig.FieldNames[idx]] = fieldType
+			}
+		}
+		for _, fieldType := range fileconfig.CaptureTypes {
+			if err := checkFieldType(fieldType); err != nil {
+				return err
+			}
+		}
+		if fileconfig.CSV != nil {
+			return fileconfig.CSV.check()
+		}
+	}
 	return nil
 }
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimestampConfig :
+// field: name of the parsed field holding the time of the event
+// layouts: go layouts (2006-01-02 15:04:05) or strftime formats (%Y-%m-%d %H:%M:%S),
+// tried in order. UNIX, UNIX_MS and UNIX_US parse epoch seconds, milliseconds and microseconds.
+// timezone: location used if the layout has no zone, default Local
+// fallback: what @timestamp is if the field is missing or could not be parsed.
+// now (default) uses the current time, none drops @timestamp. failures are tagged anyway.
+type TimestampConfig struct {
+	Field    string   `json:"field"`
+	Layouts  []string `json:"layouts"`
+	Timezone string   `json:"timezone"`
+	Fallback string   `json:"fallback"`
+
+	layouts  []string
+	yearless []bool // the layout has no year, like syslog's "Jan _2 15:04:05"
+	location *time.Location
+}
+
+// tag added to the event if @timestamp could not be parsed from the field
+const timestampFailureTag = "_timestampparsefailure"
+
+var strftimeLayouts = map[byte]string{
+	'Y': "2006",
+	'y': "06",
+	'm': "01",
+	'd': "02",
+	'e': "_2",
+	'b': "Jan",
+	'h': "Jan",
+	'B': "January",
+	'a': "Mon",
+	'A': "Monday",
+	'H': "15",
+	'I': "03",
+	'M': "04",
+	'S': "05",
+	'p': "PM",
+	'z': "-0700",
+	'Z': "MST",
+	'T': "15:04:05",
+	'F': "2006-01-02",
+	'%': "%",
+}
+
+// strftimeToLayout converts a strftime format to a go layout, layouts
+// without any '%' are returned as they are
+func strftimeToLayout(format string) (string, error) {
+	if !strings.Contains(format, "%") {
+		return format, nil
+	}
+
+	var layout strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			layout.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i == len(format) {
+			return "", fmt.Errorf("strftime format %q ends with %%", format)
+		}
+		directive, ok := strftimeLayouts[format[i]]
+		if !ok {
+			return "", fmt.Errorf("unsupported strftime directive %%%c in %q", format[i], format)
+		}
+		layout.WriteString(directive)
+	}
+	return layout.String(), nil
+}
+
+func (tc *TimestampConfig) compile() error {
+	if tc.Field == "" {
+		return errors.New("timestamp: field is required")
+	}
+	if len(tc.Layouts) == 0 {
+		return errors.New("timestamp: at least one layout is required")
+	}
+	switch tc.Fallback {
+	case "", "now", "none":
+	default:
+		return fmt.Errorf("timestamp: unknown fallback %q, should be now or none", tc.Fallback)
+	}
+
+	tc.layouts = make([]string, len(tc.Layouts))
+	tc.yearless = make([]bool, len(tc.Layouts))
+	for i, format := range tc.Layouts {
+		layout, err := strftimeToLayout(format)
+		if err != nil {
+			return err
+		}
+		tc.layouts[i] = layout
+		// 06 is in both 2006 and 06
+		tc.yearless[i] = !strings.HasPrefix(layout, "UNIX") && !strings.Contains(layout, "06")
+	}
+
+	location := time.Local
+	if tc.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(tc.Timezone); err != nil {
+			return fmt.Errorf("timestamp: %s", err)
+		}
+	}
+	tc.location = location
+	return nil
+}
+
+// parse parses value with the layouts in order
+func (tc *TimestampConfig) parse(value string) (time.Time, error) {
+	var err error
+	for i, layout := range tc.layouts {
+		var t time.Time
+		switch layout {
+		case "UNIX", "UNIX_MS":
+			var f float64
+			if f, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+			if layout == "UNIX_MS" {
+				f /= 1000
+			}
+			return time.Unix(0, int64(f*float64(time.Second))), nil
+		case "UNIX_US":
+			// too many digits for a float64 to keep the microseconds
+			var us int64
+			if us, err = strconv.ParseInt(value, 10, 64); err != nil {
+				continue
+			}
+			return time.Unix(0, us*int64(time.Microsecond)), nil
+		default:
+			if tc.yearless[i] {
+				t, err = tc.parseYearless(layout, value, time.Now())
+			} else {
+				t, err = time.ParseInLocation(layout, value, tc.location)
+			}
+			if err != nil {
+				continue
+			}
+		}
+		return t, nil
+	}
+	return time.Time{}, err
+}
+
+// parseYearless parses value with a layout without year, the year closest to
+// now is taken. the year is put in the value before parsing, so Feb 29 is only
+// parsed in a leap year.
+func (tc *TimestampConfig) parseYearless(layout, value string, now time.Time) (time.Time, error) {
+	now = now.In(tc.location)
+	var closest time.Time
+	var err error
+	for year := now.Year() - 1; year <= now.Year()+1; year++ {
+		t, e := time.ParseInLocation("2006 "+layout, strconv.Itoa(year)+" "+value, tc.location)
+		if e != nil {
+			err = e
+			continue
+		}
+		if closest.IsZero() || absDuration(t.Sub(now)) < absDuration(closest.Sub(now)) {
+			closest = t
+		}
+	}
+	if closest.IsZero() {
+		return closest, err
+	}
+	return closest, nil
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// timestamp returns the @timestamp of event. it is parsed from the field set
+// in the Timestamp config of the event if there is one, a parsed field or one
+// of the Fields. otherwise it is the Time of the event, or the current time,
+// as when the codec failed and the text only has the failure tag of the codec.
+func (f *formatState) timestamp(event *FileEvent) (time.Time, bool) {
+	tc := event.Timestamp
+	if tc == nil || f.codecFailed {
+		if !event.Time.IsZero() {
+			return event.Time, true
+		}
+		return time.Now(), true
+	}
+
+	value, ok := f.values[tc.Field]
+	if !ok {
+		value, ok = (*event.Fields)[tc.Field]
+	}
+	if ok {
+		t, err := tc.parse(value)
+		if err == nil {
+			return t, true
+		}
+	}
+
+	f.tag(timestampFailureTag)
+	if tc.Fallback == "none" {
+		return time.Time{}, false
+	}
+	return time.Now(), true
+}
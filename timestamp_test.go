@@ -0,0 +1,84 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestStrftimeToLayout(t *testing.T) {
+	layout, err := strftimeToLayout("%d/%b/%Y:%H:%M:%S %z")
+	chkerr(t, err)
+	if layout != "02/Jan/2006:15:04:05 -0700" {
+		t.Fatalf("Unexpected layout: %q", layout)
+	}
+
+	if _, err := strftimeToLayout("%Q"); err == nil {
+		t.Fatalf("Expected an error for unsupported directive")
+	}
+}
+
+func TestJsonFormatTimestamp(t *testing.T) {
+	tc := &TimestampConfig{
+		Field:    "time",
+		Layouts:  []string{"UNIX", "%Y-%m-%d %H:%M:%S"},
+		Timezone: "UTC",
+	}
+	chkerr(t, tc.compile())
+
+	event := newTestEvent("2015-01-02 03:04:05|GET")
+	event.DelimiterRegexp = regexp.MustCompile(`\|`)
+	event.FieldNames = []string{"time", "method"}
+	event.FieldNamesLength = 2
+	event.NoTimestamp = false
+	event.Timestamp = tc
+
+	decoded := decodeJsonFormat(t, event)
+	if decoded["@timestamp"] != float64(1420167845000) {
+		t.Fatalf("Expected @timestamp parsed from the time field, got %v", decoded["@timestamp"])
+	}
+
+	// with fallback none, an unparsable time drops @timestamp and tags the event
+	tc.Fallback = "none"
+	text := "yesterday|GET"
+	event.Text = &text
+	decoded = decodeJsonFormat(t, event)
+	if _, ok := decoded["@timestamp"]; ok {
+		t.Fatalf("Expected no @timestamp, got %v", decoded["@timestamp"])
+	}
+	if tags, _ := decoded["tags"].([]interface{}); len(tags) != 1 || tags[0] != timestampFailureTag {
+		t.Fatalf("Expected %s tag, got %v", timestampFailureTag, decoded["tags"])
+	}
+}
+
+func TestTimestampYearless(t *testing.T) {
+	tc := &TimestampConfig{Field: "time", Layouts: []string{"%b %e %H:%M:%S"}, Timezone: "UTC"}
+	chkerr(t, tc.compile())
+
+	tests := []struct {
+		now, value, expected string
+	}{
+		{"2025-01-01T00:00:10Z", "Dec 31 23:59:59", "2024-12-31T23:59:59Z"},
+		{"2024-12-31T23:59:59Z", "Jan  1 00:00:10", "2025-01-01T00:00:10Z"},
+		{"2024-03-01T00:00:00Z", "Feb 29 12:00:00", "2024-02-29T12:00:00Z"},
+		{"2025-03-01T00:00:00Z", "Feb 29 12:00:00", "2024-02-29T12:00:00Z"},
+	}
+	for _, test := range tests {
+		now, _ := time.Parse(time.RFC3339, test.now)
+		parsed, err := tc.parseYearless(tc.layouts[0], test.value, now)
+		chkerr(t, err)
+		if expected, _ := time.Parse(time.RFC3339, test.expected); !parsed.Equal(expected) {
+			t.Errorf("Expected %q at %s to be %s, got %s", test.value, test.now, test.expected, parsed)
+		}
+	}
+
+	// the year is taken from the time of the event
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, expected := range []time.Time{now.AddDate(0, -2, 0), now.AddDate(0, 2, 0)} {
+		parsed, err := tc.parse(expected.Format(tc.layouts[0]))
+		chkerr(t, err)
+		if !parsed.Equal(expected) {
+			t.Errorf("Expected %s, got %s", expected, parsed)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF | syscall.IN_ONLYDIR
+
+// inotifyWatcher watches directories with inotify
+type inotifyWatcher struct {
+	fd     int
+	mutex  sync.Mutex
+	dirs   map[int32]string // by watch descriptor
+	events chan dirEvent
+}
+
+func newDirWatcher(events chan dirEvent) (dirWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &inotifyWatcher{fd: fd, dirs: make(map[int32]string), events: events}
+	go w.read()
+	return w, nil
+}
+
+func (w *inotifyWatcher) watch(dir string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+	if err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+	w.dirs[int32(wd)] = dir
+	return nil
+}
+
+func (w *inotifyWatcher) read() {
+	buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := syscall.Read(w.fd, buffer)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || n < syscall.SizeofInotifyEvent {
+			emit("inotify: read failed (%d bytes): %v, files are polled now\n", n, err)
+			// everyone waits again with their timeouts
+			w.events <- dirEvent{}
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			offset = nameStart + int(raw.Len)
+			if offset > n {
+				break
+			}
+			name := string(bytes.TrimRight(buffer[nameStart:offset], "\x00"))
+			w.dispatch(raw.Wd, raw.Mask, name)
+		}
+	}
+}
+
+func (w *inotifyWatcher) dispatch(wd int32, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.events <- dirEvent{}
+		return
+	}
+
+	w.mutex.Lock()
+	dir, ok := w.dirs[wd]
+	if ok && mask&syscall.IN_IGNORED != 0 {
+		delete(w.dirs, wd)
+	}
+	w.mutex.Unlock()
+	if !ok {
+		return
+	}
+
+	switch {
+	case mask&syscall.IN_IGNORED != 0:
+		w.events <- dirEvent{dir: dir}
+	case mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF) != 0:
+		// IN_IGNORED follows the deletion, a moved directory is not where it was watched anymore
+		if mask&syscall.IN_MOVE_SELF != 0 {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+		}
+	case name != "":
+		created := mask&(syscall.IN_CREATE|syscall.IN_DELETE|syscall.IN_MOVED_FROM|syscall.IN_MOVED_TO) != 0
+		w.events <- dirEvent{dir: dir, name: name, created: created}
+	}
+}
@@ -0,0 +1,9 @@
+// +build !linux
+
+package main
+
+import "errors"
+
+func newDirWatcher(events chan dirEvent) (dirWatcher, error) {
+	return nil, errors.New("watching files is only supported on linux")
+}